@@ -6,6 +6,7 @@ import (
 	"slices"
 
 	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+	"github.com/WilkinsonK/grawp/grawpadmin/output"
 	"github.com/WilkinsonK/grawp/grawpadmin/service"
 	"github.com/WilkinsonK/grawp/grawpadmin/service/models"
 	"github.com/WilkinsonK/grawp/grawpadmin/util"
@@ -13,9 +14,27 @@ import (
 )
 
 var (
-	Manifest        manifest.GrawpManifest
-	ImageFindOpts   models.ServiceImageFindOpts
-	ServiceFindOpts models.ServiceContainerFindOpts
+	Manifest         manifest.GrawpManifest
+	BuildRecreate    bool
+	ContextList      bool
+	ExecOpts         service.ServiceExecOpts
+	ImagePruneDryRun bool
+	ImagePruneKeep   uint
+	ImageFindOpts    models.ServiceImageFindOpts
+	LogsOpts         service.ServiceLogsOpts
+	ServiceFindOpts  models.ServiceContainerFindOpts
+	PruneDryRun      bool
+	RestoreOpts      service.ArchiveRestoreOpts
+	VerifyAll        bool
+	WatchAll         bool
+)
+
+var (
+	ImagesOutput   = output.Format{Kind: output.KindTable}
+	ManifestOutput = output.Format{Kind: output.KindYaml}
+	ServicesOutput = output.Format{Kind: output.KindTable}
+	StatusOutput   = output.Format{Kind: output.KindTable}
+	VerifyOutput   = output.Format{Kind: output.KindTable}
 )
 
 var rootCommand = &cobra.Command{
@@ -30,9 +49,35 @@ var archiveServiceCommand = &cobra.Command{
 	Use:     "archive",
 	Short:   "Create tar ball(s) of server assets.",
 	Args:    cobra.ExactArgs(0),
+	PreRunE: initDatabase,
 	RunE:    ArchiveService,
 }
 
+var pruneArchiveCommand = &cobra.Command{
+	Use:     "prune",
+	Short:   "Remove archives no longer kept by their retention policy",
+	Args:    cobra.ExactArgs(0),
+	PreRunE: initDatabase,
+	RunE:    PruneArchives,
+}
+
+var restoreArchiveCommand = &cobra.Command{
+	Use:     "restore <target>",
+	Short:   "Restore an archive of an archive target",
+	Args:    cobra.ExactArgs(1),
+	PreRunE: initDatabase,
+	RunE:    RestoreArchive,
+}
+
+var verifyArchiveCommand = &cobra.Command{
+	Use:     "verify [target]",
+	Short:   "Re-read archives and report any that are corrupt",
+	Long:    "Verifies the most recent archive of each archive target, or of a single target, against its checksums.",
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: initDatabase,
+	RunE:    VerifyArchives,
+}
+
 var buildImageCommand = &cobra.Command{
 	Use:   "build",
 	Short: "Build a container image",
@@ -68,6 +113,34 @@ var initImageServiceCommand = &cobra.Command{
 	RunE:  NewService,
 }
 
+var contextImageCommand = &cobra.Command{
+	Use:   "context",
+	Short: "Show the build context sent to build a service image",
+	Args:  cobra.ExactArgs(0),
+	RunE:  PrintImageContext,
+}
+
+var pruneImagesCommand = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove all but the most recent images of each service",
+	Args:  cobra.ExactArgs(0),
+	RunE:  PruneImages,
+}
+
+var removeImagesCommand = &cobra.Command{
+	Use:   "remove <name:tag>",
+	Short: "Remove a service image",
+	Args:  cobra.ExactArgs(1),
+	RunE:  RemoveImage,
+}
+
+var syncImagesCommand = &cobra.Command{
+	Use:   "sync",
+	Short: "Reconcile service images with the images Docker has",
+	Args:  cobra.ExactArgs(0),
+	RunE:  SyncImages,
+}
+
 var listImagesCommand = &cobra.Command{
 	Use:   "list",
 	Short: "List service images available",
@@ -89,6 +162,50 @@ var printManifestCommand = &cobra.Command{
 	RunE:  PrintManifest,
 }
 
+var rconImageServiceCommand = &cobra.Command{
+	Use:   "rcon <name> [command...]",
+	Short: "Send commands to a service container over RCON",
+	Long:  "Sends a single command to a service container over RCON, or reads commands from stdin if none are given.",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  RconService,
+}
+
+var execImageServiceCommand = &cobra.Command{
+	Use:   "exec <name> <command...>",
+	Short: "Run a command in a running service container",
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  ExecService,
+}
+
+var logsImageServiceCommand = &cobra.Command{
+	Use:   "logs <name>",
+	Short: "Show the logs of a service container",
+	Args:  cobra.ExactArgs(1),
+	RunE:  LogsService,
+}
+
+var removeImageServiceCommand = &cobra.Command{
+	Aliases: []string{"rm"},
+	Use:     "remove <name>",
+	Short:   "Stop and remove a service container, keeping its volume",
+	Args:    cobra.ExactArgs(1),
+	RunE:    RemoveService,
+}
+
+var restartImageServiceCommand = &cobra.Command{
+	Use:   "restart <name>",
+	Short: "Gracefully stop a service container and start it again",
+	Args:  cobra.ExactArgs(1),
+	RunE:  RestartService,
+}
+
+var stopImageServiceCommand = &cobra.Command{
+	Use:   "stop <name>",
+	Short: "Gracefully stop a service container",
+	Args:  cobra.ExactArgs(1),
+	RunE:  StopService,
+}
+
 var rebuildSelf = &cobra.Command{
 	Aliases: []string{"rs"},
 	Use:     "rebuild-self",
@@ -97,12 +214,33 @@ var rebuildSelf = &cobra.Command{
 	RunE:    RebuildSelf,
 }
 
+var downImageServicesCommand = &cobra.Command{
+	Use:   "down [name...]",
+	Short: "Stop services in reverse dependency order",
+	Args:  cobra.ArbitraryArgs,
+	RunE:  ServicesDown,
+}
+
+var statusImageServicesCommand = &cobra.Command{
+	Use:   "status [name]",
+	Short: "Show the status, resource usage and players of services",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  StatusServices,
+}
+
+var upImageServicesCommand = &cobra.Command{
+	Use:   "up [name...]",
+	Short: "Build, create and start services in dependency order",
+	Args:  cobra.ArbitraryArgs,
+	RunE:  ServicesUp,
+}
+
 var watchImageServiceCommand = &cobra.Command{
 	Aliases: []string{"start"},
-	Use:     "watch <name>",
-	Short:   "Start and watch a running service container",
-	Long:    "Watches a service container, restarting it if failure is detected.",
-	Args:    cobra.ExactArgs(1),
+	Use:     "watch [name...]",
+	Short:   "Start and watch running service containers",
+	Long:    "Watches one or more service containers, restarting them if failure is detected.",
+	Args:    watchServiceArgs,
 	PreRunE: initDatabase,
 	RunE:    WatchService,
 }
@@ -128,6 +266,10 @@ func commonImageFlagsC(cmd *cobra.Command) {
 	cmd.Flags().StringVarP(&Manifest.GetMetadata().Image.Name, "manifest-name", "m", "service.yaml", "Service manifest file name")
 }
 
+func outputFlagC(cmd *cobra.Command, format *output.Format) {
+	cmd.Flags().VarP(format, "output", "o", "Output format (table, json, yaml or template=<go-template>)")
+}
+
 func init() {
 	if gm, err := manifest.LoadGrawpManifest(); err != nil {
 		panic(err)
@@ -138,12 +280,21 @@ func init() {
 	initCommandArchiveService()
 	initCommandBuildImage()
 	initCommandBuildImageService()
+	initCommandContextImage()
+	initCommandExecService()
 	initCommandImages()
 	initCommandImageServices()
 	initCommandInitImageService()
 	initCommandListImages()
 	initCommandListImageServices()
+	initCommandLogsService()
 	initCommandPrintManifest()
+	initCommandPruneArchive()
+	initCommandPruneImages()
+	initCommandRconService()
+	initCommandRestoreArchive()
+	initCommandStatusServices()
+	initCommandVerifyArchive()
 	initCommandWatchService()
 
 	subcmds := []*cobra.Command{
@@ -165,12 +316,14 @@ func init() {
 func initCommandArchiveService() {
 	cmd := archiveServiceCommand
 	commonImageFlags(cmd)
+	cmd.AddCommand(pruneArchiveCommand, restoreArchiveCommand, verifyArchiveCommand)
 }
 
 func initCommandBuildImage() {
 	cmd := buildImageCommand
 	cmd.Flags().StringArrayVarP(&Manifest.GetMetadata().Image.BuildArgs, "build-arg", "b", []string{}, "Build arguments, as <key>=<value> pairs, to pass at construction")
 	cmd.Flags().StringArrayVarP(&Manifest.GetMetadata().Image.BuildProperties, "property", "P", []string{}, "Build properties, as <key>=<value> pairs, to pass at construction")
+	cmd.Flags().StringVar(&Manifest.GetMetadata().Image.LogFormat, "log-format", "text", "Format of the build output (text or json)")
 	commonImageFlags(cmd)
 }
 
@@ -180,19 +333,61 @@ func initCommandBuildImageService() {
 	cmd.Flags().StringSliceVarP(&Manifest.GetMetadata().Service.ExposedPorts, "publish", "p", []string{}, "Additional ports to expose on service intialization")
 	cmd.Flags().StringVarP(&Manifest.GetMetadata().Service.TagName, "image-tag", "t", "latest", "Service image tag name to create service from")
 	cmd.Flags().StringVarP(&Manifest.GetMetadata().Service.LocalVolume, "local-volume", "v", "server", "The output directory where server assets are managed")
+	cmd.Flags().BoolVar(&BuildRecreate, "recreate", false, "Replace the service container if it exists, keeping its volume")
+	commonImageFlags(cmd)
+}
+
+func initCommandContextImage() {
+	cmd := contextImageCommand
+	cmd.Flags().BoolVarP(&ContextList, "list", "l", false, "List the files of the build context")
 	commonImageFlags(cmd)
 }
 
 func initCommandImages() {
 	cmd := imagesCommand
 	commonImagePersistentFlags(cmd)
-	cmd.AddCommand(buildImageCommand, listImagesCommand)
+	cmd.AddCommand(
+		buildImageCommand,
+		contextImageCommand,
+		listImagesCommand,
+		pruneImagesCommand,
+		removeImagesCommand,
+		syncImagesCommand,
+	)
+}
+
+func initCommandExecService() {
+	cmd := execImageServiceCommand
+	// Flags after the service name belong to the command.
+	cmd.Flags().SetInterspersed(false)
+	cmd.Flags().BoolVarP(&ExecOpts.Interactive, "interactive", "i", false, "Pass stdin to the command")
+	cmd.Flags().StringVarP(&ExecOpts.User, "user", "u", "", "User to run the command as")
+}
+
+func initCommandLogsService() {
+	cmd := logsImageServiceCommand
+	cmd.Flags().BoolVarP(&LogsOpts.Follow, "follow", "f", false, "Keep streaming new output")
+	cmd.Flags().StringVarP(&LogsOpts.Tail, "tail", "n", "all", "Number of lines to show from the end of the logs")
+	cmd.Flags().BoolVarP(&LogsOpts.Timestamps, "timestamps", "t", false, "Show timestamps")
 }
 
 func initCommandImageServices() {
 	cmd := imageServicesCommand
 	commonImagePersistentFlags(cmd)
-	cmd.AddCommand(buildImageServiceCommand, listImageServicesCommand, initImageServiceCommand)
+	cmd.AddCommand(
+		buildImageServiceCommand,
+		downImageServicesCommand,
+		execImageServiceCommand,
+		listImageServicesCommand,
+		initImageServiceCommand,
+		logsImageServiceCommand,
+		rconImageServiceCommand,
+		removeImageServiceCommand,
+		restartImageServiceCommand,
+		statusImageServicesCommand,
+		stopImageServiceCommand,
+		upImageServicesCommand,
+	)
 }
 
 func initCommandInitImageService() {
@@ -208,6 +403,7 @@ func initCommandListImages() {
 	cmd.Flags().StringVarP(&ImageFindOpts.DockerID, "id", "I", "", "Docker ID of the image")
 	cmd.Flags().StringVarP(&ImageFindOpts.Tag, "tag", "t", "", "Image tag name")
 	cmd.Flags().UintVarP(&ImageFindOpts.Limit, "limit", "l", 0, "Max number of items to return")
+	outputFlagC(cmd, &ImagesOutput)
 }
 
 func initCommandListImageServices() {
@@ -215,16 +411,74 @@ func initCommandListImageServices() {
 	cmd.Flags().StringVarP(&ServiceFindOpts.Name, "name", "N", "", "Name of the container")
 	cmd.Flags().StringVarP(&ServiceFindOpts.DockerID, "id", "I", "", "Docker ID of the container")
 	cmd.Flags().UintVarP(&ServiceFindOpts.Limit, "limit", "l", 0, "Max number of items to return")
+	outputFlagC(cmd, &ServicesOutput)
 }
 
 func initCommandPrintManifest() {
 	cmd := printManifestCommand
 	commonImageFlags(cmd)
+	outputFlagC(cmd, &ManifestOutput)
+}
+
+func initCommandPruneImages() {
+	cmd := pruneImagesCommand
+	cmd.Flags().UintVar(&ImagePruneKeep, "keep", 1, "Number of images to keep per service")
+	cmd.Flags().BoolVar(&ImagePruneDryRun, "dry-run", false, "Only report images that would be removed")
+}
+
+func initCommandPruneArchive() {
+	cmd := pruneArchiveCommand
+	cmd.Flags().BoolVar(&PruneDryRun, "dry-run", false, "Only report archives that would be removed")
+	commonImageFlags(cmd)
+}
+
+func initCommandRestoreArchive() {
+	cmd := restoreArchiveCommand
+	cmd.Flags().StringVar(&RestoreOpts.Date, "date", "", "Date, as YYYYMMDD, of the archive to restore")
+	cmd.Flags().BoolVar(&RestoreOpts.Latest, "latest", false, "Restore the most recent archive")
+	cmd.Flags().BoolVar(&RestoreOpts.Stop, "stop", false, "Stop the service container while restoring, if running")
+	cmd.MarkFlagsMutuallyExclusive("date", "latest")
+	cmd.MarkFlagsOneRequired("date", "latest")
+	commonImageFlags(cmd)
+}
+
+func initCommandRconService() {
+	cmd := rconImageServiceCommand
+	commonImageFlags(cmd)
+}
+
+func initCommandStatusServices() {
+	cmd := statusImageServicesCommand
+	outputFlagC(cmd, &StatusOutput)
+}
+
+func initCommandVerifyArchive() {
+	cmd := verifyArchiveCommand
+	cmd.Flags().BoolVarP(&VerifyAll, "all", "a", false, "Verify every archive, rather than only the most recent")
+	commonImageFlags(cmd)
+	outputFlagC(cmd, &VerifyOutput)
 }
 
 func initCommandWatchService() {
 	cmd := watchImageServiceCommand
+	restart := &Manifest.GetMetadata().Restart
 	cmd.Flags().StringVarP(&Manifest.DataName, "data-name", "d", Manifest.DataName, "Path to service data")
+	cmd.Flags().BoolVarP(&WatchAll, "all", "a", false, "Watch all known service containers")
+	cmd.Flags().StringVar(&restart.Policy, "restart-policy", "", "Restart policy overriding the service manifest (always, on-failure or never)")
+	cmd.Flags().UintVar(&restart.MaxRestarts, "max-restarts", 0, "Maximum restarts within the restart window before giving up")
+	cmd.Flags().DurationVar(&restart.Window, "restart-window", 0, "Window in which restarts are counted towards crash loop detection")
+	cmd.Flags().DurationVar(&restart.BackoffInitial, "backoff-initial", 0, "Delay before the first restart")
+	cmd.Flags().DurationVar(&restart.BackoffMax, "backoff-max", 0, "Maximum delay between restarts")
+}
+
+func watchServiceArgs(cmd *cobra.Command, args []string) error {
+	if WatchAll && len(args) > 0 {
+		return fmt.Errorf("service names cannot be given with --all")
+	}
+	if !WatchAll && len(args) == 0 {
+		return fmt.Errorf("requires at least 1 service name, or --all")
+	}
+	return nil
 }
 
 func initDatabase(cmd *cobra.Command, _ []string) error {
@@ -248,7 +502,7 @@ func ArchiveService(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 
-	return broker.ArchiveService(sm)
+	return broker.ArchiveService(sm, os.Stdout)
 }
 
 func BuildImage(cmd *cobra.Command, _ []string) error {
@@ -266,10 +520,6 @@ func BuildImage(cmd *cobra.Command, _ []string) error {
 }
 
 func BuildImageService(cmd *cobra.Command, _ []string) error {
-	// TODO: Still need to be able to rebuild the container
-	// (if necessary or user wants to).
-	// TODO: Still need to be able to build the container
-	// image if it does not exist.
 	broker, err := service.ServiceBrokerNew(&Manifest)
 	if err != nil {
 		return err
@@ -281,7 +531,30 @@ func BuildImageService(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 
-	return broker.BuildImageServiceFromManifest(sm, os.Stdout)
+	return broker.BuildImageServiceFromManifest(sm, os.Stdout, BuildRecreate)
+}
+
+func PrintImageContext(cmd *cobra.Command, _ []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+
+	sm, err := Manifest.LoadServiceManifest()
+	if err != nil {
+		return err
+	}
+	return broker.PrintImageBuildContext(os.Stdout, sm, ContextList)
+}
+
+func ExecService(cmd *cobra.Command, args []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+	return broker.ServiceExec(args[0], args[1:], ExecOpts, os.Stdin, os.Stdout, os.Stderr)
 }
 
 func ListImages(cmd *cobra.Command, _ []string) error {
@@ -290,7 +563,7 @@ func ListImages(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 	defer broker.Close()
-	return broker.ListImages(os.Stdout, ImageFindOpts)
+	return broker.ListImages(os.Stdout, ImageFindOpts, ImagesOutput)
 }
 
 func ListServices(cmd *cobra.Command, _ []string) error {
@@ -299,7 +572,16 @@ func ListServices(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 	defer broker.Close()
-	return broker.ListServices(os.Stdout, ServiceFindOpts)
+	return broker.ListServices(os.Stdout, ServiceFindOpts, ServicesOutput)
+}
+
+func LogsService(cmd *cobra.Command, args []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+	return broker.ServiceLogs(args[0], LogsOpts, os.Stdout, os.Stderr)
 }
 
 func NewService(cmd *cobra.Command, _ []string) error {
@@ -322,32 +604,173 @@ func PrintManifest(cmd *cobra.Command, _ []string) error {
 	if err != nil {
 		return err
 	}
+	return broker.PrintManifest(os.Stdout, sm, ManifestOutput)
+}
 
-	display, err := sm.Display()
+func PruneArchives(cmd *cobra.Command, _ []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
 	if err != nil {
 		return err
 	}
-	fmt.Println(display)
-	return nil
+	defer broker.Close()
+
+	sm, err := Manifest.LoadServiceManifest()
+	if err != nil {
+		return err
+	}
+	return broker.PruneArchives(sm, os.Stdout, PruneDryRun)
+}
+
+func PruneImages(cmd *cobra.Command, _ []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+	return broker.PruneImages(ImagePruneKeep, os.Stdout, ImagePruneDryRun)
+}
+
+func RemoveImage(cmd *cobra.Command, args []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+	return broker.RemoveImage(args[0], os.Stdout)
+}
+
+func RemoveService(cmd *cobra.Command, args []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+	return broker.ServiceRemove(args[0], os.Stdout)
+}
+
+func RestartService(cmd *cobra.Command, args []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+	return broker.ServiceRestart(args[0], os.Stdout)
+}
+
+func RestoreArchive(cmd *cobra.Command, args []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+
+	sm, err := Manifest.LoadServiceManifest()
+	if err != nil {
+		return err
+	}
+	RestoreOpts.Target = args[0]
+	return broker.RestoreArchive(sm, RestoreOpts, os.Stdout)
+}
+
+func RconService(cmd *cobra.Command, args []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+	return broker.RconService(args[0], args[1:], os.Stdin, os.Stdout)
 }
 
 func RebuildSelf(cmd *cobra.Command, _ []string) error {
 	return DoRebuildSelf()
 }
 
+func ServicesDown(cmd *cobra.Command, args []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+	return broker.ServicesDown(args, os.Stdout)
+}
+
+func ServicesUp(cmd *cobra.Command, args []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+	return broker.ServicesUp(args, os.Stdout)
+}
+
+func StatusServices(cmd *cobra.Command, args []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+
+	var name string
+	if len(args) > 0 {
+		name = args[0]
+	}
+	return broker.StatusServices(os.Stdout, name, StatusOutput)
+}
+
+func StopService(cmd *cobra.Command, args []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+	return broker.ServiceStop(args[0], os.Stdout)
+}
+
+func SyncImages(cmd *cobra.Command, _ []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+	return broker.SyncImages(os.Stdout)
+}
+
+func VerifyArchives(cmd *cobra.Command, args []string) error {
+	broker, err := service.ServiceBrokerNew(&Manifest)
+	if err != nil {
+		return err
+	}
+	defer broker.Close()
+
+	sm, err := Manifest.LoadServiceManifest()
+	if err != nil {
+		return err
+	}
+
+	var target string
+	if len(args) > 0 {
+		target = args[0]
+	}
+	return broker.VerifyArchives(sm, target, VerifyAll, os.Stdout, VerifyOutput)
+}
+
 func WatchService(cmd *cobra.Command, args []string) error {
 	broker, err := service.ServiceBrokerNew(&Manifest)
 	if err != nil {
 		return err
 	}
 	defer broker.Close()
-	return service.WatcherNew(broker).Watch(args[0])
+
+	watcher := service.WatcherNew(broker)
+	if WatchAll {
+		return watcher.WatchAll()
+	}
+	return watcher.Watch(args...)
 }
 
 // Tasks:
 // - Generate core assets to be packed into a new image.
 // - Archive server assets
-// - Remove assest that are too old
 func main() {
 	if err := rootCommand.Execute(); err != nil {
 		os.Exit(1)
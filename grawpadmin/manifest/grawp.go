@@ -8,6 +8,7 @@ import (
 	"slices"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/goccy/go-yaml"
 )
@@ -19,16 +20,72 @@ const grawpManifestDefaultData = "data-name: \"data.db\"\nservices-path: \"{{.Pr
 var deadPaths []string
 var foundPath string
 
+const defaultNetworkDriver = "bridge"
+
 type GrawpManifest struct {
-	metadata     GrawpManifestMetadata
-	DataName     string `json:"data-name"`
-	ServicesPath string `json:"services-path"`
-	ProjectDir   string
+	metadata GrawpManifestMetadata
+	// Where archives are kept once written. Without it,
+	// archives are kept in the archive directory of each
+	// service.
+	ArchiveStorage GrawpManifestArchiveStorage `json:"archive-storage"`
+	DataName       string                      `json:"data-name"`
+	Networks       []GrawpManifestNetwork
+	ServicesPath   string `json:"services-path"`
+	ProjectDir     string
+}
+
+const (
+	ArchiveStorageLocal = "local"
+	ArchiveStorageS3    = "s3"
+)
+
+// Describes where archives are kept. Archives of each
+// service are kept apart, under a directory or key prefix
+// named after the service.
+type GrawpManifestArchiveStorage struct {
+	// Access key of an "s3" storage. Defaults to the
+	// AWS_ACCESS_KEY_ID environment variable.
+	AccessKey string `json:"access-key"`
+	// Bucket of an "s3" storage.
+	Bucket string
+	// Endpoint of an "s3" storage, such as
+	// "http://localhost:9000". Defaults to AWS.
+	Endpoint string
+	// Either "local" or "s3".
+	Kind string
+	// Directory of a "local" storage.
+	Path string
+	// Address buckets of an "s3" storage as part of the
+	// path rather than the host name.
+	PathStyle bool `json:"path-style"`
+	// Key prefix of an "s3" storage.
+	Prefix string
+	// Region of an "s3" storage. Defaults to "us-east-1".
+	Region string
+	// Secret key of an "s3" storage. Defaults to the
+	// AWS_SECRET_ACCESS_KEY environment variable.
+	SecretKey string `json:"secret-key"`
+}
+
+// Whether archives are kept anywhere other than the
+// archive directory of each service.
+func (Gma *GrawpManifestArchiveStorage) IsDefined() bool {
+	return Gma.Kind != ""
+}
+
+// A user-defined network service containers can be attached
+// to.
+type GrawpManifestNetwork struct {
+	Driver   string
+	Internal bool
+	Name     string
+	Options  map[string]string
 }
 
 type GrawpManifestImageMetadata struct {
 	BuildArgs       []string
 	BuildProperties []string
+	LogFormat       string
 	Name            string
 	Path            string
 }
@@ -37,9 +94,18 @@ type GrawpManifestMetadata struct {
 	Image            GrawpManifestImageMetadata
 	ManifestPath     string
 	MinecraftVersion string
+	Restart          GrawpManifestRestartMetadata
 	Service          GrawpManifestServiceMetadata
 }
 
+type GrawpManifestRestartMetadata struct {
+	BackoffInitial time.Duration
+	BackoffMax     time.Duration
+	MaxRestarts    uint
+	Policy         string
+	Window         time.Duration
+}
+
 type GrawpManifestServiceMetadata struct {
 	ExposedPorts []string
 	LocalVolume  string
@@ -73,6 +139,22 @@ func (Gm *GrawpManifest) GetMetadata() *GrawpManifestMetadata {
 	return &Gm.metadata
 }
 
+// Get the definition of a network by name. Networks not
+// declared in the manifest use the default bridge driver.
+func (Gm *GrawpManifest) GetNetwork(name string) GrawpManifestNetwork {
+	network := GrawpManifestNetwork{Name: name}
+	for _, declared := range Gm.Networks {
+		if declared.Name == name {
+			network = declared
+			break
+		}
+	}
+	if network.Driver == "" {
+		network.Driver = defaultNetworkDriver
+	}
+	return network
+}
+
 func (Gm *GrawpManifest) GetServicesPath() (string, error) {
 	return Gm.formatString("ServicesPath", Gm.ServicesPath)
 }
@@ -83,7 +165,13 @@ func (Gm *GrawpManifest) GetServiceManifestPath() string {
 }
 
 func (Gm *GrawpManifest) LoadServiceManifest() (ServiceManifest, error) {
-	sm, err := LoadManifest(Gm.GetServiceManifestPath())
+	return Gm.LoadServiceManifestFrom(Gm.GetServiceManifestPath())
+}
+
+// Load a service manifest from some file path, applying
+// values from the command line metadata.
+func (Gm *GrawpManifest) LoadServiceManifestFrom(fileName string) (ServiceManifest, error) {
+	sm, err := LoadManifest(fileName)
 	if err != nil {
 		return sm, err
 	}
@@ -98,16 +186,39 @@ func (Gm *GrawpManifest) LoadServiceManifest() (ServiceManifest, error) {
 	sm.UpdatePropertiesFromSliceS(metadata.Image.BuildProperties)
 	settings.DataPath = Gm.GetDataSource()
 	settings.OutDestination = os.Stdout
+	settings.OutFormat = metadata.Image.LogFormat
 	settings.ServiceName = metadata.Service.Name
 	settings.TagName = metadata.Service.TagName
 
 	if sm.LocalVolume == "" {
 		sm.LocalVolume = metadata.Service.LocalVolume
 	}
+	Gm.applyRestartMetadata(&sm.Restart)
 
 	return sm, nil
 }
 
+// Override restart settings with those given on the
+// command line.
+func (Gm *GrawpManifest) applyRestartMetadata(restart *ServiceManifestRestart) {
+	metadata := Gm.metadata.Restart
+	if metadata.BackoffInitial > 0 {
+		restart.Backoff.Initial = metadata.BackoffInitial
+	}
+	if metadata.BackoffMax > 0 {
+		restart.Backoff.Max = metadata.BackoffMax
+	}
+	if metadata.MaxRestarts > 0 {
+		restart.MaxRestarts = metadata.MaxRestarts
+	}
+	if metadata.Policy != "" {
+		restart.Policy = metadata.Policy
+	}
+	if metadata.Window > 0 {
+		restart.Window = metadata.Window
+	}
+}
+
 // Generate the .grawp directory from the user's current
 // workding directory.
 func GenerateDotGrawp() error {
@@ -0,0 +1,150 @@
+package manifest
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const defaultServiceManifestName = "service.yaml"
+
+// Load every service manifest found directly under the
+// services path.
+func (Gm *GrawpManifest) LoadServiceManifests() ([]ServiceManifest, error) {
+	var manifests []ServiceManifest
+	root, err := Gm.GetServicesPath()
+	if err != nil {
+		return manifests, err
+	}
+
+	name := Gm.metadata.Image.Name
+	if name == "" {
+		name = defaultServiceManifestName
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return manifests, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		fileName := filepath.Join(root, entry.Name(), name)
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			continue
+		}
+		sm, err := Gm.LoadServiceManifestFrom(fileName)
+		if err != nil {
+			return manifests, err
+		}
+		manifests = append(manifests, sm)
+	}
+	return manifests, nil
+}
+
+// Sort service manifests so that every service comes after
+// the services it depends on.
+//
+// If any names are given, only those services and their
+// dependencies are returned. Services that do not depend
+// on one another are ordered by their start order, then by
+// name.
+func ResolveServiceOrder(manifests []ServiceManifest, names ...string) ([]ServiceManifest, error) {
+	var order []ServiceManifest
+	byName := make(map[string]ServiceManifest)
+	for _, sm := range manifests {
+		if _, ok := byName[sm.Name]; ok {
+			return order, fmt.Errorf("Service '%s' is defined more than once", sm.Name)
+		}
+		byName[sm.Name] = sm
+	}
+
+	if len(names) == 0 {
+		for name := range byName {
+			names = append(names, name)
+		}
+	}
+	roots, err := lookupServices(byName, names)
+	if err != nil {
+		return order, err
+	}
+	sortServices(roots)
+
+	visited := make(map[string]bool)
+	var visit func(sm ServiceManifest, path []string) error
+	visit = func(sm ServiceManifest, path []string) error {
+		if i := slices.Index(path, sm.Name); i >= 0 {
+			cycle := append(path[i:], sm.Name)
+			return fmt.Errorf("Dependency cycle detected: %s", strings.Join(cycle, " -> "))
+		}
+		if visited[sm.Name] {
+			return nil
+		}
+
+		deps, err := lookupServices(byName, sm.DependsOn)
+		if err != nil {
+			return fmt.Errorf("Service '%s': %w", sm.Name, err)
+		}
+		sortServices(deps)
+		for _, dep := range deps {
+			if err := visit(dep, append(path, sm.Name)); err != nil {
+				return err
+			}
+		}
+
+		visited[sm.Name] = true
+		order = append(order, sm)
+		return nil
+	}
+
+	for _, sm := range roots {
+		if err := visit(sm, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// Get the names of services which depend, directly or
+// indirectly, on any of the named services, including the
+// named services themselves.
+func ServiceDependents(manifests []ServiceManifest, names ...string) []string {
+	dependents := slices.Clone(names)
+	for changed := true; changed; {
+		changed = false
+		for _, sm := range manifests {
+			if slices.Contains(dependents, sm.Name) {
+				continue
+			}
+			if slices.ContainsFunc(sm.DependsOn, func(dep string) bool {
+				return slices.Contains(dependents, dep)
+			}) {
+				dependents = append(dependents, sm.Name)
+				changed = true
+			}
+		}
+	}
+	return dependents
+}
+
+func lookupServices(byName map[string]ServiceManifest, names []string) ([]ServiceManifest, error) {
+	var found []ServiceManifest
+	for _, name := range names {
+		sm, ok := byName[name]
+		if !ok {
+			return found, fmt.Errorf("Unknown service '%s'", name)
+		}
+		found = append(found, sm)
+	}
+	return found, nil
+}
+
+func sortServices(manifests []ServiceManifest) {
+	slices.SortStableFunc(manifests, func(a, b ServiceManifest) int {
+		return cmp.Or(cmp.Compare(a.StartOrder, b.StartOrder), cmp.Compare(a.Name, b.Name))
+	})
+}
@@ -4,8 +4,11 @@ package manifest
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net"
 	"os"
 	"path/filepath"
 	"slices"
@@ -16,41 +19,278 @@ import (
 	"github.com/WilkinsonK/grawp/grawpadmin/util"
 	"github.com/docker/docker/api/types/build"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
 	"github.com/goccy/go-yaml"
 	"github.com/moby/go-archive"
+	"github.com/moby/patternmatcher/ignorefile"
+)
+
+const (
+	ArchiveConsistencyNone  = "none"
+	ArchiveConsistencyPause = "pause"
+	ArchiveConsistencyRcon  = "rcon"
+)
+
+const (
+	ArchiveCompressionGzip = "gzip"
+	ArchiveCompressionNone = "none"
+	ArchiveCompressionZstd = "zstd"
 )
 
 type ServiceManifestArchiveTarget struct {
-	date    time.Time
+	date        time.Time
+	Compression ServiceManifestCompression
+	// How a running service container is kept from writing
+	// to the target while it is archived. Either "none",
+	// "rcon" to turn off saving, or "pause" to pause the
+	// container.
+	Consistency string
+	// Gitignore-like patterns, relative to the target, of
+	// files left out of the archive. As with gitignore, a
+	// file below an excluded directory cannot be included
+	// again by a negated pattern.
 	Exclude []string
+	// Number of delta archives made before the next full
+	// archive of an incremental target.
+	FullEvery uint `json:"full-every"`
+	// Only archive files changed since the previous
+	// archive of the target.
+	Incremental bool
+	// Gitignore-like patterns, relative to the target, of
+	// files archived. Without any, every file is.
 	Include []string
 	Name    string
-	Target  string
+	// Directory the entries of the archive are placed
+	// under, relative to which the target is restored.
+	Prefix    string
+	Retention ServiceManifestRetention
+	Target    string
+}
+
+// Get the consistency method of the target, defaulting to
+// "none".
+func (Sma *ServiceManifestArchiveTarget) GetConsistency() (string, error) {
+	switch Sma.Consistency {
+	case "":
+		return ArchiveConsistencyNone, nil
+	case ArchiveConsistencyNone, ArchiveConsistencyPause, ArchiveConsistencyRcon:
+		return Sma.Consistency, nil
+	default:
+		return "", fmt.Errorf("Unknown archive consistency '%s'; expected none, rcon or pause", Sma.Consistency)
+	}
+}
+
+// Get the number of delta archives made between full
+// archives, defaulting to 7.
+func (Sma *ServiceManifestArchiveTarget) GetFullEvery() uint {
+	if Sma.FullEvery == 0 {
+		return 7
+	}
+	return Sma.FullEvery
 }
 
 func (Sma *ServiceManifestArchiveTarget) TargetDate() time.Time {
 	return Sma.date
 }
 
+// Describes how the archives of a target are compressed.
+type ServiceManifestCompression struct {
+	// Either "gzip", "zstd" or "none".
+	Format string
+	// Gzip levels range from 1 to 9 and zstd levels from 1
+	// to 22. Zero picks the default of the format.
+	Level int
+	// Number of blocks compressed concurrently. Defaults
+	// to the number of CPUs.
+	Threads uint
+}
+
+// Get the extension archives are named with.
+func (Smc *ServiceManifestCompression) GetExtension() (string, error) {
+	format, err := Smc.GetFormat()
+	switch format {
+	case ArchiveCompressionGzip:
+		return ".tar.gz", err
+	case ArchiveCompressionZstd:
+		return ".tar.zst", err
+	default:
+		return ".tar", err
+	}
+}
+
+// Get the compression format, defaulting to "gzip".
+func (Smc *ServiceManifestCompression) GetFormat() (string, error) {
+	switch Smc.Format {
+	case "":
+		return ArchiveCompressionGzip, nil
+	case ArchiveCompressionGzip, ArchiveCompressionNone, ArchiveCompressionZstd:
+		return Smc.Format, nil
+	default:
+		return "", fmt.Errorf("Unknown archive compression '%s'; expected gzip, zstd or none", Smc.Format)
+	}
+}
+
+// Describes which archives of a target are kept when
+// pruning. Archives kept by any rule are not removed. If
+// no rules are defined, every archive is kept.
+type ServiceManifestRetention struct {
+	// Number of days, most recent first, to keep the
+	// latest archive of.
+	KeepDaily uint `json:"keep-daily"`
+	// Number of most recent archives to keep.
+	KeepLast uint `json:"keep-last"`
+	// Number of months, most recent first, to keep the
+	// latest archive of.
+	KeepMonthly uint `json:"keep-monthly"`
+	// Number of weeks, most recent first, to keep the
+	// latest archive of.
+	KeepWeekly uint `json:"keep-weekly"`
+	// Maximum total size of kept archives, such as "10GB".
+	// The oldest archives are removed first, but the most
+	// recent archive is always kept.
+	MaxSize string `json:"max-size"`
+}
+
+// Get the maximum total size of kept archives in bytes.
+// Returns 0 if there is no limit.
+func (Smr *ServiceManifestRetention) GetMaxSize() (int64, error) {
+	if Smr.MaxSize == "" {
+		return 0, nil
+	}
+	return units.FromHumanSize(Smr.MaxSize)
+}
+
+// Whether any retention rules are defined.
+func (Smr *ServiceManifestRetention) IsDefined() bool {
+	return Smr.KeepDaily > 0 || Smr.KeepLast > 0 || Smr.KeepMonthly > 0 || Smr.KeepWeekly > 0 || Smr.MaxSize != ""
+}
+
+// Describes the liveness probe used to detect a server
+// that stopped answering status requests.
+type ServiceManifestHealth struct {
+	Enabled bool
+	// Consecutive failed probes before the service is
+	// considered unhealthy.
+	FailureThreshold uint `json:"failure-threshold"`
+	// Host the probe connects to from the host machine.
+	Host     string
+	Interval time.Duration
+	// Container port the server listens on. Translated
+	// into its published host port, if any. Defaults to
+	// the game port.
+	Port string
+	// Time given to the server to start up before probes
+	// count towards the failure threshold.
+	StartPeriod time.Duration `json:"start-period"`
+	Timeout     time.Duration
+}
+
+// A network the service container is attached to.
+type ServiceManifestNetwork struct {
+	// Names the service can be reached by from other
+	// containers on the network. Defaults to the service
+	// name.
+	Aliases []string
+	Name    string
+}
+
+// Values describing the networks of a service, available
+// to templates as `{{.Network...}}`.
+type ServiceManifestNetworkValues struct {
+	// Names of the networks the service is attached to.
+	Names []string
+	// Addresses, as <host>:<port>, of services sharing a
+	// network with this service, by service name.
+	Services map[string]string
+}
+
 type ServiceManifestBuildSettings struct {
 	DataPath       string
 	OutDestination io.Writer
-	ServiceName    string
-	TagName        string
+	// Format build output is written as, either "text" or
+	// "json".
+	OutFormat   string
+	ServiceName string
+	TagName     string
+}
+
+const defaultShutdownGracePeriod = 30 * time.Second
+
+const (
+	ShutdownMethodAttach = "attach"
+	ShutdownMethodRcon   = "rcon"
+)
+
+// Describes how a service is brought down from within the
+// game before its container is stopped.
+type ServiceManifestShutdown struct {
+	// Console commands sent to the server, in order.
+	Commands []string
+	// How long to wait for the server to exit on its own
+	// before stopping the container.
+	GracePeriod time.Duration `json:"grace-period"`
+	// Either "rcon" or "attach". RCON falls back to
+	// attaching to the container if it is unavailable.
+	Method string
+}
+
+const (
+	RestartPolicyAlways    = "always"
+	RestartPolicyNever     = "never"
+	RestartPolicyOnFailure = "on-failure"
+)
+
+// Describes the delay between consecutive restarts.
+type ServiceManifestBackoff struct {
+	// Delay before the first restart.
+	Initial time.Duration
+	// Upper limit of the delay between restarts.
+	Max time.Duration
+	// Factor the delay grows by after each restart.
+	Multiplier float64
+	// Fraction, from 0 to 1, by which delays are randomly
+	// varied.
+	Jitter float64
+}
+
+// Describes when and how often a watched service is
+// restarted after its container exits.
+type ServiceManifestRestart struct {
+	// Exit codes treated as a deliberate stop. These are
+	// never restarted under the "on-failure" policy.
+	AllowedExitCodes []int `json:"allowed-exit-codes"`
+	Backoff          ServiceManifestBackoff
+	// Maximum number of restarts within `Window` before
+	// the service is considered to be crash looping.
+	MaxRestarts uint `json:"max-restarts"`
+	// One of "always", "on-failure" or "never".
+	Policy string
+	Window time.Duration
 }
 
 type ServiceManifest struct {
-	manifestPath     string
-	buildSettings    ServiceManifestBuildSettings
-	Archive          []ServiceManifestArchiveTarget
-	Name             string
-	Dockerfile       string
+	manifestPath  string
+	buildSettings ServiceManifestBuildSettings
+	network       ServiceManifestNetworkValues
+	Archive       []ServiceManifestArchiveTarget
+	Name          string
+	Dockerfile    string
+	// Container port players connect to, which other
+	// services on a shared network reach this service by.
+	GamePort         string `json:"game-port"`
+	Health           ServiceManifestHealth
 	MinecraftVersion string `json:"minecraft-version"`
 	Args             map[string]any
-	LocalVolume      string `json:"local-volume"`
+	DependsOn        []string `json:"depends-on"`
+	LocalVolume      string   `json:"local-volume"`
+	Networks         []ServiceManifestNetwork
 	Ports            []string
 	Properties       map[string]any
+	Restart          ServiceManifestRestart
+	Shutdown         ServiceManifestShutdown
+	StartOrder       int `json:"start-order"`
 	Tags             []string
 }
 
@@ -140,7 +380,40 @@ func (Sm *ServiceManifest) GetImageBuildSettings() *ServiceManifestBuildSettings
 // Get the image build context associated with this image
 // manifest.
 func (Sm *ServiceManifest) GetImageBuildContext() (io.ReadCloser, error) {
-	return archive.TarWithOptions(Sm.GetManifestDirectory(), &archive.TarOptions{})
+	excludes, err := Sm.GetImageBuildContextExcludes()
+	if err != nil {
+		return nil, err
+	}
+	return archive.TarWithOptions(Sm.GetManifestDirectory(), &archive.TarOptions{
+		ExcludePatterns: excludes,
+	})
+}
+
+// Get the patterns of files left out of the image build
+// context. These are read from the .dockerignore file of
+// the manifest directory, if any.
+//
+// The Dockerfile is always sent, as the daemon needs it to
+// build the image, while the archive directory never is.
+func (Sm *ServiceManifest) GetImageBuildContextExcludes() ([]string, error) {
+	var excludes []string
+	file, err := os.Open(filepath.Join(Sm.GetManifestDirectory(), ".dockerignore"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return excludes, err
+	}
+	if err == nil {
+		defer file.Close()
+		if excludes, err = ignorefile.ReadAll(file); err != nil {
+			return excludes, fmt.Errorf("Invalid .dockerignore: %w", err)
+		}
+	}
+
+	archiveDir, err := filepath.Rel(Sm.GetManifestDirectory(), Sm.GetArchiveDirectory())
+	if err != nil {
+		return excludes, err
+	}
+	excludes = append(excludes, "!"+Sm.GetDockerfile(), archiveDir)
+	return excludes, nil
 }
 
 // Generates options for building a container image.
@@ -168,12 +441,107 @@ func (Sm *ServiceManifest) GetImageBuildOptions() (build.ImageBuildOptions, erro
 	return opts, err
 }
 
+// Returns the file path from where the image manifest was
+// loaded.
+func (Sm *ServiceManifest) GetManifestPath() string {
+	return Sm.manifestPath
+}
+
 // Returns the directory from where the image manifest
 // exists on the filesystem.
 func (Sm *ServiceManifest) GetManifestDirectory() string {
 	return filepath.Dir(Sm.manifestPath)
 }
 
+// Get the health check settings, filling in defaults where
+// none are defined.
+func (Sm *ServiceManifest) GetHealth() ServiceManifestHealth {
+	health := Sm.Health
+	if health.FailureThreshold == 0 {
+		health.FailureThreshold = 3
+	}
+	if health.Host == "" {
+		health.Host = "127.0.0.1"
+	}
+	if health.Interval <= 0 {
+		health.Interval = 30 * time.Second
+	}
+	if health.Port == "" {
+		health.Port = Sm.GamePort
+	}
+	if health.StartPeriod <= 0 {
+		health.StartPeriod = 2 * time.Minute
+	}
+	if health.Timeout <= 0 {
+		health.Timeout = 5 * time.Second
+	}
+	return health
+}
+
+// Get the address, as <host>:<port>, the health check
+// probes the service at from the host machine.
+func (Sm *ServiceManifest) GetHealthAddress() (string, error) {
+	health := Sm.GetHealth()
+	if health.Port == "" {
+		return "", fmt.Errorf("Service %s defines neither a health check port nor a game port", Sm.Name)
+	}
+
+	port, err := Sm.GetHostPort(health.Port)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(health.Host, port), nil
+}
+
+// Get the host port a container port is published on.
+// Returns the container port itself if it is not
+// published.
+func (Sm *ServiceManifest) GetHostPort(port string) (string, error) {
+	_, portMap, err := Sm.GetPorts()
+	if err != nil {
+		return "", err
+	}
+	for _, binding := range portMap[nat.Port(port+"/tcp")] {
+		if binding.HostPort != "" {
+			return binding.HostPort, nil
+		}
+	}
+	return port, nil
+}
+
+// Get the address, as <host>:<port>, other containers on
+// a shared network reach this service by.
+//
+// The port is the game port of the service, as containers
+// on a network reach it directly rather than through the
+// port published on the host.
+func (Sm *ServiceManifest) GetNetworkAddress() (string, error) {
+	networks := Sm.GetNetworks()
+	if len(networks) == 0 {
+		return "", fmt.Errorf("Service %s is not attached to any network", Sm.Name)
+	}
+	if Sm.GamePort == "" {
+		return "", fmt.Errorf("Service %s does not define a game port", Sm.Name)
+	}
+	return net.JoinHostPort(networks[0].Aliases[0], Sm.GamePort), nil
+}
+
+// Get the networks the service container is attached to,
+// filling in default aliases.
+func (Sm *ServiceManifest) GetNetworks() []ServiceManifestNetwork {
+	return util.Collect(slices.Values(Sm.Networks), func(n ServiceManifestNetwork) ServiceManifestNetwork {
+		if len(n.Aliases) == 0 {
+			n.Aliases = []string{Sm.Name}
+		}
+		return n
+	})
+}
+
+// Get the network values available to templates.
+func (Sm *ServiceManifest) Network() ServiceManifestNetworkValues {
+	return Sm.network
+}
+
 // Gets the ports as mappings that can be used to bind
 // between the service container and the host.
 func (Sm *ServiceManifest) GetPorts() (nat.PortSet, nat.PortMap, error) {
@@ -189,6 +557,24 @@ func (Sm *ServiceManifest) GetPropertyS(key string) (string, error) {
 	return Sm.formatString(fmt.Sprintf("%s-property-template", key), value)
 }
 
+// Get a value from the `Properties` list as a string,
+// returning `def` if the property is not defined.
+//
+// Non-string values are formatted as-is.
+func (Sm *ServiceManifest) GetPropertyOr(key, def string) string {
+	value, ok := Sm.Properties[key]
+	if !ok || value == nil {
+		return def
+	}
+	if s, ok := value.(string); ok {
+		if rendered, err := Sm.formatString(fmt.Sprintf("%s-property-template", key), s); err == nil {
+			return rendered
+		}
+		return s
+	}
+	return fmt.Sprint(value)
+}
+
 // Attempts to generate a build config for creating a
 // service container.
 func (Sm *ServiceManifest) GetServiceBuildConfig(tagName string) (container.Config, error) {
@@ -219,6 +605,9 @@ func (Sm *ServiceManifest) GetServiceBuildConfig(tagName string) (container.Conf
 
 	config.Image = imageName
 	config.ExposedPorts = portSet
+	// Allows console commands to be sent through an
+	// attached stdin.
+	config.OpenStdin = true
 	return config, nil
 }
 
@@ -248,11 +637,70 @@ func (Sm *ServiceManifest) GetServiceHostConfig() (container.HostConfig, error)
 	return hostConfig, nil
 }
 
+// Attempts to generate the networking config used to
+// attach a service container to its networks on creation.
+func (Sm *ServiceManifest) GetServiceNetworkConfig() network.NetworkingConfig {
+	config := network.NetworkingConfig{
+		EndpointsConfig: make(map[string]*network.EndpointSettings),
+	}
+	for _, n := range Sm.GetNetworks() {
+		config.EndpointsConfig[n.Name] = &network.EndpointSettings{
+			Aliases: n.Aliases,
+		}
+	}
+	return config
+}
+
 // Get the service name to create a service container as.
 func (Sm *ServiceManifest) GetServiceName() string {
 	return strings.Join([]string{"service", Sm.Name, Sm.MinecraftVersion}, "-")
 }
 
+// Get the restart settings, filling in defaults where none
+// are defined.
+func (Sm *ServiceManifest) GetRestart() ServiceManifestRestart {
+	restart := Sm.Restart
+	if restart.AllowedExitCodes == nil {
+		restart.AllowedExitCodes = []int{0}
+	}
+	if restart.Backoff.Initial <= 0 {
+		restart.Backoff.Initial = 10 * time.Second
+	}
+	if restart.Backoff.Max <= 0 {
+		restart.Backoff.Max = 5 * time.Minute
+	}
+	if restart.Backoff.Multiplier < 1 {
+		restart.Backoff.Multiplier = 2
+	}
+	restart.Backoff.Jitter = min(max(restart.Backoff.Jitter, 0), 1)
+	if restart.MaxRestarts == 0 {
+		restart.MaxRestarts = 5
+	}
+	if restart.Policy == "" {
+		restart.Policy = RestartPolicyOnFailure
+	}
+	if restart.Window <= 0 {
+		restart.Window = 10 * time.Minute
+	}
+	return restart
+}
+
+// Get the shutdown settings, filling in defaults where
+// none are defined.
+func (Sm *ServiceManifest) GetShutdown() ServiceManifestShutdown {
+	shutdown := Sm.Shutdown
+	if shutdown.Commands == nil {
+		shutdown.Commands = []string{"save-all", "stop"}
+	}
+	if shutdown.GracePeriod <= 0 {
+		shutdown.GracePeriod = defaultShutdownGracePeriod
+	}
+	if shutdown.Method == "" {
+		shutdown.Method = ShutdownMethodRcon
+	}
+	return shutdown
+}
+
 // Get the container image tag name.
 //
 // Tags are capable of being formatted by manifest values
@@ -297,6 +745,11 @@ func (Sm *ServiceManifest) GetTemplateFiles() ([]string, error) {
 	return files, nil
 }
 
+// Set the network values available to templates.
+func (Sm *ServiceManifest) SetNetwork(values ServiceManifestNetworkValues) {
+	Sm.network = values
+}
+
 // Parse a slice of strings as <key>=<value> pairs into the
 // Args mapping.
 func (Sm *ServiceManifest) UpdateArgsFromSliceS(args []string) {
@@ -0,0 +1,137 @@
+package manifest
+
+import "testing"
+
+func TestServiceManifestGetNetworkAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name: "game port listed first",
+			manifest: `
+name: papermc
+game-port: "25565"
+networks: [{name: grawp}]
+ports: ["25565:25565", "25575:25575"]`,
+			want: "papermc:25565",
+		},
+		{
+			name: "game port not listed first",
+			manifest: `
+name: papermc
+game-port: "25565"
+networks: [{name: grawp}]
+ports: ["25575:25575", "25566:25565"]`,
+			want: "papermc:25565",
+		},
+		{
+			name: "game port not published",
+			manifest: `
+name: papermc
+game-port: "25565"
+networks: [{name: grawp}]`,
+			want: "papermc:25565",
+		},
+		{
+			name: "alias",
+			manifest: `
+name: papermc
+game-port: "25565"
+networks: [{name: grawp, aliases: [lobby]}]`,
+			want: "lobby:25565",
+		},
+		{
+			name: "no game port",
+			manifest: `
+name: papermc
+networks: [{name: grawp}]
+ports: ["25565:25565"]`,
+			wantErr: true,
+		},
+		{
+			name: "no network",
+			manifest: `
+name: papermc
+game-port: "25565"`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm, err := LoadsManifest("service.yaml", []byte(tt.manifest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := sm.GetNetworkAddress()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetNetworkAddress() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetNetworkAddress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceManifestGetHealthAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name: "game port not listed first",
+			manifest: `
+name: papermc
+game-port: "25565"
+health: {enabled: true}
+ports: ["25575:25575", "25566:25565"]`,
+			want: "127.0.0.1:25566",
+		},
+		{
+			name: "game port not published",
+			manifest: `
+name: papermc
+game-port: "25565"
+health: {enabled: true, host: 10.0.0.2}
+ports: ["25575:25575"]`,
+			want: "10.0.0.2:25565",
+		},
+		{
+			name: "health port over game port",
+			manifest: `
+name: papermc
+game-port: "25565"
+health: {enabled: true, port: "25580"}
+ports: ["25565:25565", "25581:25580"]`,
+			want: "127.0.0.1:25581",
+		},
+		{
+			name: "first port is not guessed",
+			manifest: `
+name: papermc
+health: {enabled: true}
+ports: ["25565:25565"]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm, err := LoadsManifest("service.yaml", []byte(tt.manifest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := sm.GetHealthAddress()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetHealthAddress() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetHealthAddress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
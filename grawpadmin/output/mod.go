@@ -0,0 +1,154 @@
+// Renders command output as tables, JSON, YAML or user
+// defined templates.
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/goccy/go-yaml"
+)
+
+const (
+	KindJson     = "json"
+	KindTable    = "table"
+	KindTemplate = "template"
+	KindYaml     = "yaml"
+)
+
+// Describes how items are written.
+type Format struct {
+	Kind string
+	// Go template executed once per item. Only used with
+	// the "template" kind.
+	Template string
+}
+
+func (F Format) String() string {
+	if F.Kind == KindTemplate {
+		return KindTemplate + "=" + F.Template
+	}
+	return F.Kind
+}
+
+// Set the format from a flag value.
+func (F *Format) Set(value string) error {
+	format, err := ParseFormat(value)
+	if err != nil {
+		return err
+	}
+	*F = format
+	return nil
+}
+
+func (F *Format) Type() string {
+	return "format"
+}
+
+// A column of a table, rendering one cell per item.
+type Column[T any] struct {
+	Header string
+	Value  func(T) string
+}
+
+// Parse an output format given as one of "table", "json",
+// "yaml" or "template=<go-template>".
+func ParseFormat(value string) (Format, error) {
+	kind, tmpl, found := strings.Cut(value, "=")
+	switch kind {
+	case KindJson, KindTable, KindYaml:
+		if found {
+			return Format{}, fmt.Errorf("Output format '%s' does not take a value", kind)
+		}
+		return Format{Kind: kind}, nil
+	case KindTemplate:
+		if tmpl == "" {
+			return Format{}, fmt.Errorf("Output format 'template' requires a template, as template=<go-template>")
+		}
+		return Format{Kind: kind, Template: tmpl}, nil
+	default:
+		return Format{}, fmt.Errorf("Unknown output format '%s'; expected table, json, yaml or template=<go-template>", value)
+	}
+}
+
+// Write items to `out` in the given format.
+func Write[T any](out io.Writer, format Format, items []T, columns []Column[T]) error {
+	switch format.Kind {
+	case KindJson:
+		return WriteJson(out, items)
+	case KindTable, "":
+		return WriteTable(out, items, columns)
+	case KindTemplate:
+		return WriteTemplate(out, format.Template, items)
+	case KindYaml:
+		return WriteYaml(out, items)
+	default:
+		return fmt.Errorf("Unknown output format '%s'", format.Kind)
+	}
+}
+
+// Write a value as indented JSON.
+func WriteJson(out io.Writer, value any) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(value)
+}
+
+// Write items as a table with aligned columns.
+func WriteTable[T any](out io.Writer, items []T, columns []Column[T]) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	headers := make([]string, len(columns))
+	for i, column := range columns {
+		headers[i] = column.Header
+	}
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+
+	cells := make([]string, len(columns))
+	for _, item := range items {
+		for i, column := range columns {
+			cells[i] = column.Value(item)
+			if cells[i] == "" {
+				cells[i] = "-"
+			}
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	return w.Flush()
+}
+
+// Execute a template once per item, each followed by a
+// newline.
+func WriteTemplate[T any](out io.Writer, text string, items []T) error {
+	tmpl, err := template.New("output").Parse(text)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err = tmpl.Execute(out, item); err != nil {
+			return err
+		}
+		fmt.Fprintln(out)
+	}
+	return nil
+}
+
+// Write a value as YAML.
+//
+// Values are converted through JSON first so that field
+// names match the JSON output.
+func WriteYaml(out io.Writer, value any) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.JSONToYAML(raw)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
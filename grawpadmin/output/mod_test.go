@@ -0,0 +1,158 @@
+package output
+
+import (
+	"strings"
+	"testing"
+)
+
+type testItem struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+	Tag  string `json:"tag,omitempty"`
+}
+
+var testItems = []testItem{
+	{Name: "papermc", Size: 1, Tag: "latest"},
+	{Name: "velocity", Size: 22},
+}
+
+var testColumns = []Column[testItem]{
+	{Header: "NAME", Value: func(i testItem) string { return i.Name }},
+	{Header: "TAG", Value: func(i testItem) string { return i.Tag }},
+	{Header: "SIZE", Value: func(i testItem) string { return strings.Repeat("#", i.Size%10) }},
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Format
+		wantErr bool
+	}{
+		{value: "table", want: Format{Kind: KindTable}},
+		{value: "json", want: Format{Kind: KindJson}},
+		{value: "yaml", want: Format{Kind: KindYaml}},
+		{value: "template={{.Name}}", want: Format{Kind: KindTemplate, Template: "{{.Name}}"}},
+		{value: "template=a=b", want: Format{Kind: KindTemplate, Template: "a=b"}},
+		{value: "template", wantErr: true},
+		{value: "template=", wantErr: true},
+		{value: "json=pretty", wantErr: true},
+		{value: "xml", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseFormat(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFormat(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseFormat(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+			if err == nil && got.String() != tt.value {
+				t.Errorf("String() = %q, want %q", got.String(), tt.value)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		items   []testItem
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "table",
+			format: Format{Kind: KindTable},
+			items:  testItems,
+			want: "NAME      TAG     SIZE\n" +
+				"papermc   latest  #\n" +
+				"velocity  -       ##\n",
+		},
+		{
+			name:   "table by default",
+			format: Format{},
+			items:  testItems[:1],
+			want: "NAME     TAG     SIZE\n" +
+				"papermc  latest  #\n",
+		},
+		{
+			name:   "empty table",
+			format: Format{Kind: KindTable},
+			want:   "NAME  TAG  SIZE\n",
+		},
+		{
+			name:   "json",
+			format: Format{Kind: KindJson},
+			items:  testItems,
+			want: `[
+  {
+    "name": "papermc",
+    "size": 1,
+    "tag": "latest"
+  },
+  {
+    "name": "velocity",
+    "size": 22
+  }
+]
+`,
+		},
+		{
+			name:   "yaml",
+			format: Format{Kind: KindYaml},
+			items:  testItems,
+			want: `- name: papermc
+  size: 1
+  tag: latest
+- name: velocity
+  size: 22
+`,
+		},
+		{
+			name:   "template",
+			format: Format{Kind: KindTemplate, Template: "{{.Name}}={{.Size}}"},
+			items:  testItems,
+			want:   "papermc=1\nvelocity=22\n",
+		},
+		{
+			name:   "template without items",
+			format: Format{Kind: KindTemplate, Template: "{{.Name}}"},
+		},
+		{
+			name:    "invalid template",
+			format:  Format{Kind: KindTemplate, Template: "{{.Name"},
+			items:   testItems,
+			wantErr: true,
+		},
+		{
+			name:    "template of a missing field",
+			format:  Format{Kind: KindTemplate, Template: "{{.Missing}}"},
+			items:   testItems,
+			wantErr: true,
+		},
+		{
+			name:    "unknown format",
+			format:  Format{Kind: "xml"},
+			items:   testItems,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			err := Write(&out, tt.format, tt.items, testColumns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if out.String() != tt.want {
+				t.Errorf("Write() wrote\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
@@ -2,30 +2,47 @@ package service
 
 import (
 	"archive/tar"
-	"bytes"
-	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
-	"slices"
-	"strings"
+	"time"
 
-	"github.com/WilkinsonK/grawp/grawpadmin/util"
+	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+	"github.com/WilkinsonK/grawp/grawpadmin/service/models"
+	"github.com/docker/go-units"
 )
 
+// Returned when an archive would hold no files.
+var NoFilesError = fmt.Errorf("No files to archive")
+
 type Archiver struct {
-	MaxDepth uint
-	Options  ArchiveOpts
+	// Files written to the archive, embedded as its file
+	// list once complete.
+	Contents []models.ArchiveSnapshotFile
+	// Index of the files of an incremental archive. Files
+	// unchanged since the previous archive are skipped.
+	Index   *ArchiveIndex
+	Options ArchiveOpts
+	// Checksum of the complete archive.
+	Sha256 string
 }
 
+// Write the archive, streaming files into a temporary file
+// which replaces the archive only once it is complete.
 func (A *Archiver) Archive() error {
-	A.Options.InitTar()
-	err := A.AddDirectory(A.Options.RootPath)
-	if err != nil {
+	if err := A.Options.InitTar(); err != nil {
+		return err
+	}
+	if err := A.AddDirectory(A.Options.RootPath); err != nil {
+		A.Close()
 		return err
 	}
-	A.Options.Close()
 	return A.DumpArchive()
 }
 
@@ -34,174 +51,338 @@ func (A *Archiver) AddDirectory(path string) error {
 }
 
 func (A *Archiver) AddDirectoryWalker(path string, d fs.DirEntry, err error) error {
-	if err != nil || !A.PathIsAllowed(path) || d.Type().IsDir() {
+	if err != nil || path == A.Options.RootPath {
 		return err
 	}
+	if d.IsDir() {
+		if A.Options.Exclude.MatchDirectory(A.RelativePath(path)) {
+			return filepath.SkipDir
+		}
+		if !A.PathIsAllowed(path, true) {
+			return nil
+		}
+		return A.AddEntry(path)
+	}
+	if !A.PathIsAllowed(path, false) {
+		return nil
+	}
+	if d.Type()&fs.ModeSymlink != 0 {
+		return A.AddEntry(path)
+	}
+	if !d.Type().IsRegular() {
+		fmt.Fprintln(A.Options.Out, "Skipped: ", path, "(unsupported file type)")
+		return nil
+	}
+	if A.Index != nil {
+		unchanged, err := A.Index.Unchanged(path, A.RelativePath(path))
+		if err != nil || unchanged {
+			return err
+		}
+	}
 	return A.AddFile(path)
 }
 
-func (A *Archiver) AddExcludes(patterns ...string) {
-	A.AddGlobPatterns(&A.Options.Exclude, patterns...)
+func (A *Archiver) AddExcludes(patterns ...string) error {
+	return A.AddGlobPatterns(&A.Options.Exclude, patterns...)
 }
 
-func (A *Archiver) AddIncludes(patterns ...string) {
-	A.AddGlobPatterns(&A.Options.Include, patterns...)
+func (A *Archiver) AddIncludes(patterns ...string) error {
+	return A.AddGlobPatterns(&A.Options.Include, patterns...)
 }
 
-func (A *Archiver) AddGlobPattern(container *[]string, pattern string) {
-	var parts []string
+func (A *Archiver) AddGlobPatterns(container *GlobPatterns, patterns ...string) error {
+	gps, err := GlobPatternsParse(patterns...)
+	if err != nil {
+		return err
+	}
+	*container = append(*container, gps...)
+	return nil
+}
 
-	depth := int(A.MaxDepth) - len(strings.Split(pattern, "**"))
-	for ; depth > 0; depth-- {
-		part := strings.ReplaceAll(pattern, "**", strings.Repeat("/*", int(depth)))
-		part = strings.ReplaceAll(part, "//", "/")
-		part = filepath.Join(A.Options.RootPath, part)
-		parts = append(parts, part)
+// Add a directory or symlink. Neither count towards the
+// files of the archive.
+//
+// Symlinks leading outside of the root are skipped, as
+// they would not be restored.
+func (A *Archiver) AddEntry(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
 	}
 
-	*container = append(*container, parts...)
-}
+	var link string
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+		target := filepath.Join(filepath.Dir(A.RelativePath(path)), link)
+		if filepath.IsAbs(link) || !filepath.IsLocal(target) {
+			fmt.Fprintln(A.Options.Out, "Skipped: ", path, "(links outside of the archive)")
+			return nil
+		}
+	}
 
-func (A *Archiver) AddGlobPatterns(container *[]string, patterns ...string) {
-	util.ForEach(slices.Values(patterns), func(p string) {
-		A.AddGlobPattern(container, p)
-	})
+	header, err := tar.FileInfoHeader(fi, filepath.ToSlash(link))
+	if err != nil {
+		return err
+	}
+	header.Name = A.EntryName(path, fi.IsDir())
+	header.Format = tar.FormatPAX
+	return A.Options.Tar.WriteHeader(header)
 }
 
 func (A *Archiver) AddFile(path string) error {
-	fi, err := os.Stat(path)
+	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	header, err := tar.FileInfoHeader(fi, "")
-	header.Name = path
+	fi, err := file.Stat()
 	if err != nil {
 		return err
 	}
 
-	contents, err := os.ReadFile(path)
+	header, err := tar.FileInfoHeader(fi, "")
 	if err != nil {
 		return err
 	}
+	header.Name = A.EntryName(path, false)
+	header.Format = tar.FormatPAX
 
 	if err = A.Options.Tar.WriteHeader(header); err != nil {
 		return err
 	}
-	if _, err = A.Options.Tar.Write(contents); err != nil {
+	// The header promises the size of the file at the time
+	// it was opened; files that keep growing are cut off.
+	hash := sha256.New()
+	if _, err = io.CopyN(A.Options.Tar, io.TeeReader(file, hash), header.Size); err != nil {
 		return err
 	}
-	fmt.Println("Added: ", path)
-	return nil
-}
-
-func (A *Archiver) GetMaxDepth(path string) (uint, error) {
-	return A.MaxDepth, filepath.WalkDir(path, A.GetMaxDepthWalker)
-}
-
-func (A *Archiver) GetMaxDepthWalker(path string, d fs.DirEntry, err error) error {
-	if err != nil || d.Type().IsDir() {
-		return err
+	sum := hex.EncodeToString(hash.Sum(nil))
+	A.Contents = append(A.Contents, models.ArchiveSnapshotFile{
+		Path:    header.Name,
+		Size:    header.Size,
+		ModTime: fi.ModTime(),
+		Sha256:  sum,
+	})
+	if A.Index != nil {
+		A.Index.Add(A.RelativePath(path), fi, sum)
 	}
-	A.MaxDepth = max(A.MaxDepth, uint(len(strings.Split(path, "/"))))
+	A.Options.Progress.AddFile()
+	fmt.Fprintln(A.Options.Out, "Added: ", path)
 	return nil
 }
 
+// Discard the archive, removing its temporary file.
 func (A *Archiver) Close() error {
-	A.Options.Buffer.Reset()
-	return A.Options.Close()
+	A.Options.Close()
+	if A.Options.File != nil {
+		os.Remove(A.Options.File.Name())
+		A.Options.File = nil
+	}
+	return nil
 }
 
+// Finish writing the archive, moving the temporary file
+// into place next to a file holding its checksum.
 func (A *Archiver) DumpArchive() error {
-	opts := A.Options
+	opts := &A.Options
 	name := filepath.Join(opts.Path, opts.Name)
-	byts := opts.Buffer.Bytes()
 
-	if len(byts) <= 32 {
-		return fmt.Errorf("No files to archive a %s", opts.RootPath)
+	// Delta archives of incremental targets are written
+	// even if empty when files were removed.
+	if opts.Progress.Files == 0 && (A.Index == nil || !A.Index.Changed()) {
+		A.Close()
+		return fmt.Errorf("%w at %s", NoFilesError, opts.RootPath)
 	}
 
-	err := os.WriteFile(name, byts, 0755)
-	if err != nil {
+	if err := ArchiveContentsWrite(opts.Tar, A.Contents); err != nil {
+		A.Close()
+		return err
+	}
+	if err := opts.Close(); err != nil {
+		A.Close()
+		fmt.Fprintf(os.Stderr, "Error writing to file %s: %s\n", name, err)
+		return err
+	}
+	if err := os.Rename(opts.File.Name(), name); err != nil {
+		A.Close()
 		fmt.Fprintf(os.Stderr, "Error writing to file %s: %s\n", name, err)
 		return err
 	}
-	fmt.Printf("Archive written to %s\n", name)
+	opts.File = nil
+
+	A.Sha256 = hex.EncodeToString(opts.Hash.Sum(nil))
+	if err := ArchiveChecksumWrite(name, A.Sha256); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing to file %s: %s\n", ArchiveChecksumName(name), err)
+		return err
+	}
+
+	fmt.Fprintf(opts.Out, "Archive written to %s (%s)\n", name, opts.Progress)
 	return nil
 }
 
-func (A *Archiver) PathIsAllowed(path string) bool {
-	return !A.PathIsExcluded(path) && A.PathIsIncluded(path)
+// Get the name of the archive entry of a path, relative to
+// the root and placed under the prefix of the archive.
+func (A *Archiver) EntryName(path string, isDir bool) string {
+	name := filepath.ToSlash(filepath.Join(filepath.FromSlash(A.Options.Prefix), A.RelativePath(path)))
+	if isDir {
+		name += "/"
+	}
+	return name
 }
 
-func (A *Archiver) PathIsExcluded(path string) bool {
-	if len(A.Options.Exclude) == 0 {
-		return false
+// Get a path relative to the root of the archive.
+func (A *Archiver) RelativePath(path string) string {
+	if rel, err := filepath.Rel(A.Options.RootPath, path); err == nil {
+		return filepath.ToSlash(rel)
 	}
+	return filepath.ToSlash(path)
+}
 
-	var notAllowed bool = false
-	for _, pattern := range A.Options.Exclude {
-		if yes, err := filepath.Match(pattern, path); err != nil || !yes {
-			continue
-		}
-		notAllowed = true
-	}
+// Whether a path is included and not excluded. Patterns
+// are matched against the path relative to the root.
+func (A *Archiver) PathIsAllowed(path string, isDir bool) bool {
+	return !A.PathIsExcluded(path, isDir) && A.PathIsIncluded(path, isDir)
+}
 
-	return notAllowed
+func (A *Archiver) PathIsExcluded(path string, isDir bool) bool {
+	return A.Options.Exclude.Match(A.RelativePath(path), isDir)
 }
 
-func (A *Archiver) PathIsIncluded(path string) bool {
+// Whether a path is included. Without any include
+// patterns, every path is.
+func (A *Archiver) PathIsIncluded(path string, isDir bool) bool {
 	if len(A.Options.Include) == 0 {
 		return true
 	}
-
-	var isAllowed bool = false
-	for _, pattern := range A.Options.Include {
-		if yes, err := filepath.Match(pattern, path); err != nil || !yes {
-			continue
-		}
-		isAllowed = true
-	}
-
-	return isAllowed
+	return A.Options.Include.Match(A.RelativePath(path), isDir)
 }
 
 type ArchiveOpts struct {
-	Buffer bytes.Buffer
+	Compression manifest.ServiceManifestCompression
+	// Writer compressing the archive into the file.
+	Compressor io.WriteCloser
+	// Temporary file the archive is streamed into.
+	File *os.File
 	// Patterns to include in archive.
-	Include []string
+	Include GlobPatterns
 	// Patterns to exclude from archive.
-	Exclude  []string
-	Name     string
-	Path     string
+	Exclude GlobPatterns
+	// Checksum of everything written to the file.
+	Hash hash.Hash
+	Name string
+	// Where progress and the files added are reported.
+	Out  io.Writer
+	Path string
+	// Directory entries are placed under in the archive.
+	Prefix   string
+	Progress *ArchiveProgress
 	RootPath string
-	Gzip     *gzip.Writer
 	Tar      *tar.Writer
 }
 
+// Flush and close the archive writers.
 func (Ao *ArchiveOpts) Close() error {
-	Ao.Tar.Close()
-	Ao.Gzip.Close()
+	var errs []error
+	if Ao.Tar != nil {
+		errs = append(errs, Ao.Tar.Close())
+		Ao.Tar = nil
+	}
+	if Ao.Compressor != nil {
+		errs = append(errs, Ao.Compressor.Close())
+		Ao.Compressor = nil
+	}
+	if Ao.File != nil {
+		errs = append(errs, Ao.File.Sync())
+		errs = append(errs, Ao.File.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Create the temporary file next to the archive and the
+// writers streaming into it.
+func (Ao *ArchiveOpts) InitTar() error {
+	file, err := os.CreateTemp(Ao.Path, "."+Ao.Name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	file.Chmod(defaultFileMode)
+	Ao.File = file
+	Ao.Progress = ArchiveProgressNew(Ao.Out)
+	Ao.Hash = sha256.New()
+	Ao.Compressor, err = ArchiveCompressorNew(io.MultiWriter(file, Ao.Hash, Ao.Progress), Ao.Compression)
+	if err != nil {
+		Ao.Close()
+		os.Remove(file.Name())
+		Ao.File = nil
+		return err
+	}
+	Ao.Tar = tar.NewWriter(Ao.Compressor)
 	return nil
 }
 
-func (Ao *ArchiveOpts) InitTar() {
-	Ao.Gzip = gzip.NewWriter(&Ao.Buffer)
-	Ao.Tar = tar.NewWriter(Ao.Gzip)
+// Tracks and periodically reports how many bytes have been
+// written to an archive.
+type ArchiveProgress struct {
+	Bytes    int64
+	Files    uint
+	Interval time.Duration
+	out      io.Writer
+	reported time.Time
+	started  time.Time
+}
+
+func (Ap *ArchiveProgress) AddFile() {
+	Ap.Files++
+}
+
+// Bytes written per second since the archive was started.
+func (Ap *ArchiveProgress) Rate() float64 {
+	elapsed := time.Since(Ap.started).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(Ap.Bytes) / elapsed
+}
+
+func (Ap *ArchiveProgress) String() string {
+	return fmt.Sprintf("%d files, %s in %s, %s/s",
+		Ap.Files,
+		units.BytesSize(float64(Ap.Bytes)),
+		time.Since(Ap.started).Round(time.Millisecond),
+		units.BytesSize(Ap.Rate()))
+}
+
+func (Ap *ArchiveProgress) Write(p []byte) (int, error) {
+	Ap.Bytes += int64(len(p))
+	if time.Since(Ap.reported) >= Ap.Interval {
+		Ap.reported = time.Now()
+		fmt.Fprintf(Ap.out, "Progress: %s\n", Ap)
+	}
+	return len(p), nil
+}
+
+func ArchiveProgressNew(out io.Writer) *ArchiveProgress {
+	now := time.Now()
+	return &ArchiveProgress{
+		Interval: 5 * time.Second,
+		out:      out,
+		reported: now,
+		started:  now,
+	}
 }
 
 func ArchiverNew(opts ArchiveOpts) Archiver {
-	a := Archiver{Options: opts}
-	a.GetMaxDepth(a.Options.RootPath)
-	return a
+	return Archiver{Options: opts}
 }
 
-func ArchiveOptsNew(name, path, rootPath string) ArchiveOpts {
-	ao := ArchiveOpts{
+func ArchiveOptsNew(name, path, rootPath string, out io.Writer) ArchiveOpts {
+	return ArchiveOpts{
 		Name:     name,
+		Out:      out,
 		Path:     path,
 		RootPath: rootPath,
 	}
-	ao.InitTar()
-	return ao
 }
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+	"github.com/docker/go-units"
+)
+
+// Number of files in the trees archived, so trees of
+// different sizes only differ in how large their files are.
+const archiveTreeFiles = 16
+
+// Generate a tree of `size` bytes spread over
+// `archiveTreeFiles` files in nested directories.
+func archiveTree(tb testing.TB, size int) string {
+	tb.Helper()
+	root := tb.TempDir()
+	rng := rand.New(rand.NewChaCha8([32]byte{}))
+	data := make([]byte, size/archiveTreeFiles)
+	for i := range archiveTreeFiles {
+		dir := filepath.Join(root, fmt.Sprintf("region%d", i%4))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		for j := range data {
+			data[j] = byte(rng.UintN(16))
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("r.%d.mca", i)), data, 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return root
+}
+
+// Archive `root` into `dir` with the parallel gzip writer.
+func archiveTreeOnce(tb testing.TB, root, dir string) {
+	tb.Helper()
+	opts := ArchiveOptsNew("tree.tar.gz", dir, root, io.Discard)
+	opts.Compression = manifest.ServiceManifestCompression{Threads: 4}
+	a := ArchiverNew(opts)
+	if err := a.Archive(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// Bytes allocated while archiving `root`.
+func archiveTreeAllocs(tb testing.TB, root string) uint64 {
+	tb.Helper()
+	dir := tb.TempDir()
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	archiveTreeOnce(tb, root, dir)
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func BenchmarkArchive(b *testing.B) {
+	for _, size := range []int{16 << 20, 64 << 20, 256 << 20} {
+		b.Run(units.BytesSize(float64(size)), func(b *testing.B) {
+			root := archiveTree(b, size)
+			dir := b.TempDir()
+			b.ReportAllocs()
+			b.SetBytes(int64(size))
+			for b.Loop() {
+				archiveTreeOnce(b, root, dir)
+			}
+		})
+	}
+}
+
+// Archives are streamed, so archiving a tree four times as
+// large allocates about as much.
+func TestArchiveAllocsConstant(t *testing.T) {
+	if testing.Short() {
+		t.Skip("archives large trees")
+	}
+	small := archiveTreeAllocs(t, archiveTree(t, 16<<20))
+	large := archiveTreeAllocs(t, archiveTree(t, 64<<20))
+	t.Logf("allocated %s for 16MiB, %s for 64MiB",
+		units.BytesSize(float64(small)), units.BytesSize(float64(large)))
+	if large > small+small/2 {
+		t.Errorf("allocations grew with tree size: %d bytes for 16MiB, %d bytes for 64MiB", small, large)
+	}
+}
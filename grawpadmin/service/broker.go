@@ -1,46 +1,181 @@
 package service
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+	"github.com/WilkinsonK/grawp/grawpadmin/output"
 	"github.com/WilkinsonK/grawp/grawpadmin/service/models"
 	"github.com/WilkinsonK/grawp/grawpadmin/util"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-units"
+	"github.com/goccy/go-yaml"
 )
 
 type ServiceManifestCallback func(manifest.ServiceManifest) error
 
+// A service container along with the state of its Docker
+// container.
+type ServiceContainerListing struct {
+	models.ServiceContainer
+	Status string `json:"status"`
+}
+
+// Options of a command run in a service container.
+type ServiceExecOpts struct {
+	// Pass stdin to the command.
+	Interactive bool
+	// User the command is run as, if not the container
+	// default.
+	User string
+}
+
+// Options of how service container logs are streamed.
+type ServiceLogsOpts struct {
+	// Keep streaming new output.
+	Follow bool
+	// Number of lines to show from the end of the logs, or
+	// "all".
+	Tail string
+	// Prefix each line with its timestamp.
+	Timestamps bool
+}
+
 type ServiceBroker struct {
 	Client   *client.Client
 	Database *sql.DB
 	Manifest *manifest.GrawpManifest
 }
 
-func (Sb *ServiceBroker) ArchiveService(sm manifest.ServiceManifest) error {
-	var err error
+func (Sb *ServiceBroker) ArchiveService(sm manifest.ServiceManifest, out io.Writer) error {
 	archivePath := sm.GetArchiveDirectory()
 	os.MkdirAll(archivePath, defaultFileMode)
+	storage, err := Sb.ArchiveStorage(sm)
+	if err != nil {
+		return err
+	}
 	util.ForEach(slices.Values(sm.GetArchiveTargets()), func(target manifest.ServiceManifestArchiveTarget) {
 		if err != nil {
 			return
 		}
+		err = Sb.ArchiveTarget(sm, target, archivePath, storage, out)
+	})
+	return err
+}
+
+// Get the storage the archives of a service are kept in.
+func (Sb *ServiceBroker) ArchiveStorage(sm manifest.ServiceManifest) (ArchiveStorage, error) {
+	return ArchiveStorageNew(Sb.Manifest.ArchiveStorage, sm.Name, sm.GetArchiveDirectory())
+}
+
+// Archive a single target, holding the service container
+// as the target's consistency requires for the duration.
+//
+// The archive is written to `archivePath` before being
+// copied into `storage`. Progress is reported to `out`.
+func (Sb *ServiceBroker) ArchiveTarget(sm manifest.ServiceManifest, target manifest.ServiceManifestArchiveTarget, archivePath string, storage ArchiveStorage, out io.Writer) (err error) {
+	consistency, err := target.GetConsistency()
+	if err != nil {
+		return err
+	}
+
+	ext, err := target.Compression.GetExtension()
+	if err != nil {
+		return fmt.Errorf("%s: %w", target.Name, err)
+	}
+	name := ArchiveFileName(target.Name, target.TargetDate(), ext)
+	var previous models.ArchiveSnapshot
+	var delta bool
+	if target.Incremental {
+		files, snapshots, err := ArchiveSnapshotFiles(Sb.Database, sm.Name, target, storage)
+		if err != nil {
+			return err
+		}
+		if previous, delta = ArchiveSnapshotPrevious(snapshots, target, files); delta {
+			name = ArchiveDeltaFileName(target.Name, target.TargetDate(), ext)
+		}
+	}
+
+	opts := ArchiveOptsNew(name, archivePath, target.Target, out)
+	opts.Compression = target.Compression
+	opts.Prefix = target.Prefix
+	a := ArchiverNew(opts)
+	defer a.Close()
+	if err = errors.Join(a.AddIncludes(target.Include...), a.AddExcludes(target.Exclude...)); err != nil {
+		return fmt.Errorf("%s: %w", target.Name, err)
+	}
+	if target.Incremental {
+		a.Index = ArchiveIndexNew(previous.Files)
+	}
+
+	// Only a running service container has to be held.
+	var model models.ServiceContainer
+	if consistency != manifest.ArchiveConsistencyNone {
+		var found bool
+		if model, found, err = Sb.FindManifestContainer(sm); err != nil {
+			return err
+		}
+		if !found || Sb.GetServiceContainerStatus(model) != "running" {
+			consistency = manifest.ArchiveConsistencyNone
+		}
+	}
 
-		date := target.TargetDate()
-		year, month, day := date.Year(), date.Month(), date.Day()
-		name := fmt.Sprintf("%s-%04d%02d%02d.tar.gz", target.Name, year, month, day)
+	resume, err := ArchiveHold(Sb.Client, model, sm, consistency, out)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	ArchiveResumeOnSignal(resume, func() { a.Close() }, done)
+	defer func() { err = errors.Join(err, resume()) }()
 
-		a := ArchiverNew(ArchiveOptsNew(name, archivePath, target.Target))
-		defer a.Close()
-		a.AddIncludes(target.Include...)
-		a.AddExcludes(target.Exclude...)
-		err = a.Archive()
+	if err = a.Archive(); err != nil {
+		if delta && errors.Is(err, NoFilesError) {
+			fmt.Fprintf(out, "No changes to %s since %s\n", target.Name, previous.Archive)
+			return nil
+		}
+		return err
+	}
+	// The container need not be held while uploading.
+	if err = resume(); err != nil {
+		return err
+	}
+	if err = ArchiveUpload(storage, archivePath, name, out); err != nil {
+		return err
+	}
+	if err = Sb.CatalogArchive(sm, target, &a); err != nil || !target.Incremental {
+		return err
+	}
+
+	snapshot, err := models.ArchiveSnapshotNew(models.ArchiveSnapshotNewOpts{
+		Service: sm.Name,
+		Target:  target.Name,
+		Archive: name,
+		Parent:  previous.Archive,
+		Date:    target.TargetDate(),
+		Files:   a.Index.Files,
 	})
+	if err != nil {
+		return err
+	}
+	_, err = models.ArchiveSnapshotPut(Sb.Database, snapshot)
 	return err
 }
 
@@ -53,11 +188,62 @@ func (Sb *ServiceBroker) BuildImageFromManifest(sm manifest.ServiceManifest) err
 	return err
 }
 
-func (Sb *ServiceBroker) BuildImageServiceFromManifest(sm manifest.ServiceManifest, out io.Writer) error {
-	model, err := BuildServiceFromManifest(Sb.Client, sm)
+// Create the service container of a manifest, building its
+// image first if missing.
+//
+// An existing service container is reported, and only
+// replaced if `recreate` is set. It is renamed aside while
+// the replacement is created, so is kept should creating
+// it fail. Once replaced, it is stopped and removed,
+// leaving its volume in place, and the replacement started
+// if it was running.
+func (Sb *ServiceBroker) BuildImageServiceFromManifest(sm manifest.ServiceManifest, out io.Writer, recreate bool) error {
+	existing, found, err := Sb.FindManifestContainer(sm)
+	if err != nil {
+		return err
+	}
+	if found && !recreate {
+		fmt.Fprintf(out, "Service container %s already exists; use --recreate to replace it\n", existing.Name)
+		return nil
+	}
+	if err = Sb.EnsureImage(sm, out); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	running, renamed := false, false
+	if found {
+		running = Sb.GetServiceContainerStatus(existing) == "running"
+		err = Sb.Client.ContainerRename(ctx, existing.DockerId, existing.Name+"-replaced")
+		if err != nil && !cerrdefs.IsNotFound(err) {
+			return err
+		}
+		renamed = err == nil
+	}
+
+	model, err := Sb.CreateServiceContainer(sm)
 	if err != nil {
+		if model.DockerId != "" {
+			err = errors.Join(err, Sb.Client.ContainerRemove(ctx, model.DockerId, container.RemoveOptions{}))
+		}
+		if renamed {
+			err = errors.Join(err, Sb.Client.ContainerRename(ctx, existing.DockerId, existing.Name))
+		}
 		return err
 	}
+	if found {
+		// The record of the replacement has already taken the
+		// place of the existing one.
+		if err = Sb.RemoveServiceContainer(existing, sm, out); err != nil {
+			return err
+		}
+	}
+	if running {
+		fmt.Fprintf(out, "Starting %s...\n", model.Name)
+		if err = Sb.Client.ContainerStart(ctx, model.DockerId, container.StartOptions{}); err != nil {
+			return err
+		}
+	}
 
 	fmt.Fprint(out, model.Name)
 	if model.DockerId != "" {
@@ -67,12 +253,102 @@ func (Sb *ServiceBroker) BuildImageServiceFromManifest(sm manifest.ServiceManife
 	return err
 }
 
+// Record a written archive in the archive catalog.
+func (Sb *ServiceBroker) CatalogArchive(sm manifest.ServiceManifest, target manifest.ServiceManifestArchiveTarget, a *Archiver) error {
+	ac, err := models.ArchiveCatalogNew(models.ArchiveCatalogNewOpts{
+		Service: sm.Name,
+		Target:  target.Name,
+		Archive: a.Options.Name,
+		Date:    target.TargetDate(),
+		Size:    a.Options.Progress.Bytes,
+		Files:   a.Options.Progress.Files,
+		Sha256:  a.Sha256,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = models.ArchiveCatalogPut(Sb.Database, ac)
+	return err
+}
+
 func (Sb *ServiceBroker) Close() error {
 	Sb.Client.Close()
 	Sb.Database.Close()
 	return nil
 }
 
+// Create a service container, along with any networks it
+// is attached to.
+func (Sb *ServiceBroker) CreateServiceContainer(sm manifest.ServiceManifest) (models.ServiceContainer, error) {
+	if err := Sb.EnsureNetworks(sm); err != nil {
+		return models.ServiceContainer{}, err
+	}
+	return BuildServiceFromManifest(Sb.Client, sm)
+}
+
+// Build the image a service container is created from, if
+// missing.
+func (Sb *ServiceBroker) EnsureImage(sm manifest.ServiceManifest, out io.Writer) error {
+	exists, err := Sb.ImageExists(sm)
+	if err != nil || exists {
+		return err
+	}
+	fmt.Fprintf(out, "Building image for %s...\n", sm.Name)
+	return Sb.BuildImage(sm)
+}
+
+// Create or reuse the networks a service is attached to.
+func (Sb *ServiceBroker) EnsureNetworks(sm manifest.ServiceManifest) error {
+	for _, n := range sm.GetNetworks() {
+		if _, err := NetworkEnsure(Sb.Client, Sb.Manifest.GetNetwork(n.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Find the service container created from a manifest, if
+// one exists.
+func (Sb *ServiceBroker) FindManifestContainer(sm manifest.ServiceManifest) (models.ServiceContainer, bool, error) {
+	found, err := models.ServiceContainerFind(Sb.Database, models.ServiceContainerFindOpts{
+		Name:  manifestServiceName(sm),
+		Limit: 1,
+	})
+	if err != nil || len(found) == 0 {
+		return models.ServiceContainer{}, false, err
+	}
+	return found[0], true, nil
+}
+
+// Find a service container by name along with the
+// `ServiceManifest` it was created from.
+//
+// Containers created before manifests were tracked fall
+// back to the manifest given on the command line.
+func (Sb *ServiceBroker) FindServiceContainer(name string) (models.ServiceContainer, manifest.ServiceManifest, error) {
+	var model models.ServiceContainer
+	var sm manifest.ServiceManifest
+
+	found, err := models.ServiceContainerFind(Sb.Database, models.ServiceContainerFindOpts{
+		Name:  name,
+		Limit: 1,
+	})
+	if err != nil {
+		return model, sm, err
+	}
+	if len(found) == 0 {
+		return model, sm, fmt.Errorf("No service container named '%s'", name)
+	}
+	model = found[0]
+
+	if model.Manifest != "" {
+		sm, err = Sb.Manifest.LoadServiceManifestFrom(model.Manifest)
+	} else {
+		sm, err = Sb.Manifest.LoadServiceManifest()
+	}
+	return model, sm, err
+}
+
 func (Sb *ServiceBroker) GetServiceContainerStatus(model models.ServiceContainer) string {
 	resp, err := Sb.Client.ContainerInspect(context.Background(), model.DockerId)
 	if err != nil {
@@ -82,41 +358,905 @@ func (Sb *ServiceBroker) GetServiceContainerStatus(model models.ServiceContainer
 	}
 }
 
+// Whether the image a service container is created from
+// exists. Service image records of an image Docker no
+// longer has are marked unavailable.
+func (Sb *ServiceBroker) ImageExists(sm manifest.ServiceManifest) (bool, error) {
+	exists, err := ImageExistsFromManifest(Sb.Client, sm)
+	if err != nil {
+		return false, err
+	}
+	ref, err := ImageReferenceFromManifest(sm)
+	if err != nil {
+		return false, err
+	}
+
+	name, tag := ImageReferenceSplit(ref)
+	records, err := models.ServiceImagesFind(Sb.Database, models.ServiceImageFindOpts{Name: name, Tag: tag})
+	if err != nil {
+		return false, err
+	}
+	for _, record := range records {
+		if record.IsAvailable != exists {
+			record.IsAvailable = exists
+			if _, err = models.ServiceImageUpdate(Sb.Database, record); err != nil {
+				return false, err
+			}
+		}
+	}
+	return exists, nil
+}
+
 func (Sb *ServiceBroker) InitDatabase() error {
 	return models.InitDatabaseTables(Sb.Database)
 }
 
-func (Sb *ServiceBroker) ListImages(out io.Writer, opts models.ServiceImageFindOpts) error {
-	models, err := models.ServiceImagesFind(Sb.Database, opts)
+func (Sb *ServiceBroker) ListImages(out io.Writer, opts models.ServiceImageFindOpts, format output.Format) error {
+	images, err := models.ServiceImagesFind(Sb.Database, opts)
 	if err != nil {
 		return err
 	}
-	for _, model := range models {
-		fmt.Printf("%s\t%s   \t%s   \t%s\t%v\n", model.Uuid, model.Name, model.Tag, model.DockerID, model.IsAvailable)
+	return output.Write(out, format, images, []output.Column[models.ServiceImage]{
+		{Header: "UUID", Value: func(m models.ServiceImage) string { return m.Uuid.String() }},
+		{Header: "NAME", Value: func(m models.ServiceImage) string { return m.Name }},
+		{Header: "TAG", Value: func(m models.ServiceImage) string { return m.Tag }},
+		{Header: "DOCKER ID", Value: func(m models.ServiceImage) string { return m.DockerID }},
+		{Header: "AVAILABLE", Value: func(m models.ServiceImage) string { return strconv.FormatBool(m.IsAvailable) }},
+	})
+}
+
+// Print the size of the image build context of a service
+// and, if `list` is set, the files it is made of.
+func (Sb *ServiceBroker) PrintImageBuildContext(out io.Writer, sm manifest.ServiceManifest, list bool) error {
+	entries, size, err := BuildContextList(sm)
+	if err != nil {
+		return err
+	}
+	if list {
+		err = output.WriteTable(out, entries, []output.Column[BuildContextEntry]{
+			{Header: "PATH", Value: func(e BuildContextEntry) string { return e.Path }},
+			{Header: "SIZE", Value: func(e BuildContextEntry) string { return units.BytesSize(float64(e.Size)) }},
+		})
+		if err != nil {
+			return err
+		}
 	}
+	fmt.Fprintf(out, "Build context of %s: %d files, %s\n", sm.Name, len(entries), units.BytesSize(float64(size)))
 	return nil
 }
 
-func (Sb *ServiceBroker) ListServices(out io.Writer, opts models.ServiceContainerFindOpts) error {
-	models, err := models.ServiceContainerFind(Sb.Database, opts)
+// Remove all but the `keep` most recent images of each
+// service, oldest first.
+//
+// Images are told apart by their Docker ID, so the tags of
+// an image are kept or removed together. Images Docker no
+// longer has count as the oldest. Images still used by a
+// container are skipped, and any image failing to be
+// removed does not stop the rest from being pruned.
+func (Sb *ServiceBroker) PruneImages(keep uint, out io.Writer, dryRun bool) error {
+	manifests, err := Sb.Manifest.LoadServiceManifests()
+	if err != nil {
+		return err
+	}
+	records, err := models.ServiceImagesList(Sb.Database)
+	if err != nil {
+		return err
+	}
+	summaries, err := Sb.Client.ImageList(context.Background(), image.ListOptions{})
+	if err != nil {
+		return err
+	}
+	created := make(map[string]int64)
+	for _, summary := range summaries {
+		created[summary.ID] = summary.Created
+	}
+
+	var errs []error
+	for _, sm := range manifests {
+		names, err := manifestImageNames(sm)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sm.Name, err))
+			continue
+		}
+		byId := make(map[string][]models.ServiceImage)
+		for _, record := range records {
+			if slices.Contains(names, record.Name) {
+				byId[record.DockerID] = append(byId[record.DockerID], record)
+			}
+		}
+
+		// Most recent first.
+		ids := slices.SortedFunc(maps.Keys(byId), func(a, b string) int {
+			return cmp.Compare(created[b], created[a])
+		})
+		if uint(len(ids)) <= keep {
+			continue
+		}
+		for _, id := range slices.Backward(ids[keep:]) {
+			if dryRun {
+				for _, record := range byId[id] {
+					fmt.Fprintf(out, "Would remove %s:%s\n", record.Name, record.Tag)
+				}
+				continue
+			}
+			err = Sb.removeImages(out, byId[id]...)
+			if cerrdefs.IsConflict(err) {
+				fmt.Fprintf(out, "Skipped %s: in use\n", imageRefs(byId[id]))
+			} else if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Remove a service image, given as <name>:<tag>, from
+// Docker along with its record.
+func (Sb *ServiceBroker) RemoveImage(ref string, out io.Writer) error {
+	name, tag := ImageReferenceSplit(ref)
+	records, err := models.ServiceImagesFind(Sb.Database, models.ServiceImageFindOpts{Name: name, Tag: tag})
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("No service image named '%s:%s'", name, tag)
+	}
+	return Sb.removeImages(out, records...)
+}
+
+// Reconcile service image records with the images Docker
+// has.
+//
+// Records of images Docker no longer has are marked
+// unavailable. Images tagged as a service manifest tags
+// them, but not yet recorded, are imported.
+func (Sb *ServiceBroker) SyncImages(out io.Writer) error {
+	summaries, err := Sb.Client.ImageList(context.Background(), image.ListOptions{})
 	if err != nil {
 		return err
 	}
-	for _, model := range models {
-		status := Sb.GetServiceContainerStatus(model)
-		fmt.Fprintf(out, "%s\t%s   \t%s   \t%s\n", model.Uuid, model.Name, model.DockerId, status)
+	tagged := make(map[string]string)
+	for _, summary := range summaries {
+		for _, tag := range summary.RepoTags {
+			tagged[tag] = summary.ID
+		}
+	}
+
+	records, err := models.ServiceImagesList(Sb.Database)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, record := range records {
+		ref := record.Name + ":" + record.Tag
+		known[ref] = true
+		id, available := tagged[ref]
+		if record.IsAvailable == available && (!available || record.DockerID == id) {
+			continue
+		}
+
+		record.IsAvailable = available
+		if available {
+			record.DockerID = id
+			fmt.Fprintf(out, "Updated %s\n", ref)
+		} else {
+			fmt.Fprintf(out, "Marked %s unavailable\n", ref)
+		}
+		if _, err = models.ServiceImageUpdate(Sb.Database, record); err != nil {
+			return err
+		}
+	}
+
+	manifests, err := Sb.Manifest.LoadServiceManifests()
+	if err != nil {
+		return err
+	}
+	for _, sm := range manifests {
+		tags, err := sm.GetTags()
+		if err != nil {
+			return fmt.Errorf("%s: %w", sm.Name, err)
+		}
+		for _, tag := range tags {
+			name, tag := ImageReferenceSplit(tag)
+			ref := name + ":" + tag
+			id, available := tagged[ref]
+			if known[ref] || !available {
+				continue
+			}
+
+			model, err := models.ServiceImageNew(models.ServiceImageNewOptions{
+				Name:     name,
+				Tag:      tag,
+				DockerId: id,
+			})
+			if err != nil {
+				return err
+			}
+			if _, err = models.ServiceImagePut(Sb.Database, model); err != nil {
+				return err
+			}
+			known[ref] = true
+			fmt.Fprintf(out, "Imported %s\n", ref)
+		}
 	}
 	return nil
 }
 
+func (Sb *ServiceBroker) ListServices(out io.Writer, opts models.ServiceContainerFindOpts, format output.Format) error {
+	found, err := models.ServiceContainerFind(Sb.Database, opts)
+	if err != nil {
+		return err
+	}
+	listings := util.Collect(slices.Values(found), func(model models.ServiceContainer) ServiceContainerListing {
+		return ServiceContainerListing{ServiceContainer: model, Status: Sb.GetServiceContainerStatus(model)}
+	})
+	return output.Write(out, format, listings, []output.Column[ServiceContainerListing]{
+		{Header: "UUID", Value: func(l ServiceContainerListing) string { return l.Uuid.String() }},
+		{Header: "NAME", Value: func(l ServiceContainerListing) string { return l.Name }},
+		{Header: "DOCKER ID", Value: func(l ServiceContainerListing) string { return l.DockerId }},
+		{Header: "STATUS", Value: func(l ServiceContainerListing) string { return l.Status }},
+	})
+}
+
+// Write a service manifest. Values in YAML and JSON output
+// are rendered from their templates.
+func (Sb *ServiceBroker) PrintManifest(out io.Writer, sm manifest.ServiceManifest, format output.Format) error {
+	switch format.Kind {
+	case output.KindJson, output.KindYaml:
+		display, err := sm.Display()
+		if err != nil {
+			return err
+		}
+		if format.Kind == output.KindYaml {
+			_, err = fmt.Fprintln(out, display)
+			return err
+		}
+
+		raw, err := yaml.YAMLToJSON([]byte(display))
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err = json.Indent(&buf, raw, "", "  "); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, buf.String())
+		return err
+	}
+
+	return output.Write(out, format, []manifest.ServiceManifest{sm}, []output.Column[manifest.ServiceManifest]{
+		{Header: "NAME", Value: func(sm manifest.ServiceManifest) string { return sm.Name }},
+		{Header: "MINECRAFT VERSION", Value: func(sm manifest.ServiceManifest) string { return sm.MinecraftVersion }},
+		{Header: "LOCAL VOLUME", Value: func(sm manifest.ServiceManifest) string { return sm.LocalVolume }},
+		{Header: "PORTS", Value: func(sm manifest.ServiceManifest) string { return strings.Join(sm.Ports, ",") }},
+		{Header: "TAGS", Value: func(sm manifest.ServiceManifest) string {
+			tags, _ := sm.GetTags()
+			return strings.Join(tags, ",")
+		}},
+	})
+}
+
+// Report the status of one service container, or of all
+// known service containers if `name` is empty.
+func (Sb *ServiceBroker) StatusServices(out io.Writer, name string, format output.Format) error {
+	var names []string
+	if name != "" {
+		names = append(names, name)
+	} else {
+		found, err := models.ServiceContainerFind(Sb.Database, models.ServiceContainerFindOpts{})
+		if err != nil {
+			return err
+		}
+		for _, model := range found {
+			names = append(names, model.Name)
+		}
+	}
+
+	var statuses []ServiceStatus
+	for _, name := range names {
+		model, sm, err := Sb.FindServiceContainer(name)
+		if err != nil {
+			return err
+		}
+		status, err := ServiceStatusFromContainer(Sb.Client, model, sm)
+		if err != nil {
+			// Keep the state of containers which could be
+			// inspected, but not gathered stats from.
+			if status.State == "" {
+				status.State = "unknown"
+			}
+			status.Error = err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+
+	return WriteServiceStatus(out, statuses, format)
+}
+
 func (Sb *ServiceBroker) NewService() error {
 	return ServiceNew(*Sb.Manifest)
 }
 
+// Send commands to a service container over RCON.
+//
+// If no commands are given, commands are read line by line
+// from `in` until exhausted.
+func (Sb *ServiceBroker) RconService(name string, commands []string, in io.Reader, out io.Writer) error {
+	_, sm, err := Sb.FindServiceContainer(name)
+	if err != nil {
+		return err
+	}
+
+	client, err := RconDialFromManifest(sm)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if len(commands) > 0 {
+		return RconSend(client, strings.Join(commands, " "), out)
+	}
+	return RconRepl(client, in, out)
+}
+
+// Remove archives no longer kept by the retention policy
+// of their archive target.
+//
+// Archives needed to restore a kept delta archive of an
+// incremental target are kept as well.
+//
+// With `dryRun`, archives are only reported.
+func (Sb *ServiceBroker) PruneArchives(sm manifest.ServiceManifest, out io.Writer, dryRun bool) error {
+	storage, err := Sb.ArchiveStorage(sm)
+	if err != nil {
+		return err
+	}
+	for _, target := range sm.GetArchiveTargets() {
+		var files []ArchiveFile
+		var snapshots []models.ArchiveSnapshot
+		if target.Incremental {
+			files, snapshots, err = ArchiveSnapshotFiles(Sb.Database, sm.Name, target, storage)
+		} else {
+			files, err = ArchiveFilesFind(storage, target.Name)
+		}
+		if err != nil {
+			return err
+		}
+		keep, remove, err := RetentionPlan(files, target.Retention)
+		if err != nil {
+			return fmt.Errorf("%s: %w", target.Name, err)
+		}
+		if target.Incremental {
+			remove = RetentionKeepChains(keep, remove, snapshots)
+		}
+
+		for _, file := range remove {
+			if dryRun {
+				fmt.Fprintf(out, "Would remove %s\n", file.Path)
+				continue
+			}
+			if err = ArchiveStorageRemove(storage, file.Name); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Removed %s\n", file.Path)
+
+			removed := slices.DeleteFunc(slices.Clone(snapshots), func(s models.ArchiveSnapshot) bool {
+				return s.Archive != file.Name
+			})
+			if _, err = models.ArchiveSnapshotDel(Sb.Database, removed...); err != nil {
+				return err
+			}
+			cataloged, err := models.ArchiveCatalogFind(Sb.Database, models.ArchiveCatalogFindOpts{
+				Service: sm.Name,
+				Target:  target.Name,
+				Archive: file.Name,
+			})
+			if err != nil {
+				return err
+			}
+			if _, err = models.ArchiveCatalogDel(Sb.Database, cataloged...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (Sb *ServiceBroker) RenderManifestFiles(sm manifest.ServiceManifest) error {
+	if err := Sb.ResolveNetworkValues(&sm); err != nil {
+		return err
+	}
 	return RenderAllFromManifest(&sm)
 }
 
+// Fill in the network values of a service from the other
+// services under the services path.
+func (Sb *ServiceBroker) ResolveNetworkValues(sm *manifest.ServiceManifest) error {
+	manifests, err := Sb.Manifest.LoadServiceManifests()
+	if err != nil {
+		return err
+	}
+	sm.SetNetwork(NetworkValuesFromManifests(*sm, manifests))
+	return nil
+}
+
+// Build, create and start a single service container,
+// skipping whatever already exists.
+func (Sb *ServiceBroker) ServiceUp(sm manifest.ServiceManifest, out io.Writer) error {
+	model, found, err := Sb.FindManifestContainer(sm)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		if err = Sb.EnsureImage(sm, out); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "Creating %s...\n", manifestServiceName(sm))
+		if model, err = Sb.CreateServiceContainer(sm); err != nil {
+			return err
+		}
+	}
+
+	if Sb.GetServiceContainerStatus(model) == "running" {
+		fmt.Fprintf(out, "%s is already running\n", model.Name)
+		return nil
+	}
+	fmt.Fprintf(out, "Starting %s...\n", model.Name)
+	return Sb.Client.ContainerStart(context.Background(), model.DockerId, container.StartOptions{})
+}
+
+// Remove images from Docker along with their records.
+// Images Docker no longer has only have their records
+// removed.
+func (Sb *ServiceBroker) removeImages(out io.Writer, images ...models.ServiceImage) error {
+	for _, record := range images {
+		ref := record.Name + ":" + record.Tag
+		_, err := Sb.Client.ImageRemove(context.Background(), ref, image.RemoveOptions{PruneChildren: true})
+		if err != nil && !cerrdefs.IsNotFound(err) {
+			return fmt.Errorf("%s: %w", ref, err)
+		}
+		if _, err = models.ServiceImageDel(Sb.Database, record); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Removed %s\n", ref)
+	}
+	return nil
+}
+
+// Stop a service container, if running, and remove it
+// along with its record. The volume of the service is left
+// in place.
+func (Sb *ServiceBroker) RemoveServiceContainer(model models.ServiceContainer, sm manifest.ServiceManifest, out io.Writer) error {
+	err := Sb.StopServiceContainer(model, sm, out)
+	if err != nil && !cerrdefs.IsNotFound(err) {
+		return err
+	}
+
+	fmt.Fprintf(out, "Removing %s...\n", model.Name)
+	err = Sb.Client.ContainerRemove(context.Background(), model.DockerId, container.RemoveOptions{})
+	if err != nil && !cerrdefs.IsNotFound(err) {
+		return err
+	}
+	_, err = models.ServiceContainerDel(Sb.Database, model)
+	return err
+}
+
+// Restore an archive of a target over its target path.
+//
+// Whatever the archive replaces is kept in a safety
+// archive first, then cleared, so no files newer than the
+// archive are left behind. A running service container is
+// only stopped if `opts.Stop` is set, and is started again
+// afterwards even if the restore fails.
+func (Sb *ServiceBroker) RestoreArchive(sm manifest.ServiceManifest, opts ArchiveRestoreOpts, out io.Writer) (err error) {
+	target, err := ArchiveTargetFind(sm, opts.Target)
+	if err != nil {
+		return err
+	}
+
+	archivePath := sm.GetArchiveDirectory()
+	storage, err := Sb.ArchiveStorage(sm)
+	if err != nil {
+		return err
+	}
+	var files []ArchiveFile
+	var snapshots []models.ArchiveSnapshot
+	if target.Incremental {
+		files, snapshots, err = ArchiveSnapshotFiles(Sb.Database, sm.Name, target, storage)
+	} else {
+		files, err = ArchiveFilesFind(storage, target.Name)
+	}
+	if err != nil {
+		return err
+	}
+	file, err := ArchiveFileSelect(files, opts)
+	if err != nil {
+		return err
+	}
+
+	// Incremental archives are restored by extracting the
+	// full archive and every delta up to the one selected.
+	chain := []models.ArchiveSnapshot{{Archive: file.Name}}
+	if target.Incremental {
+		if chain, err = ArchiveSnapshotChain(snapshots, file.Name); err != nil {
+			return err
+		}
+	}
+	// Archives kept elsewhere are downloaded for the
+	// duration of the restore.
+	paths := make(map[string]string)
+	for _, snapshot := range chain {
+		path, cleanup, err := ArchiveFetch(storage, archivePath, snapshot.Archive)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		paths[snapshot.Archive] = path
+
+		fmt.Fprintf(out, "Verifying %s...\n", storage.Location(snapshot.Archive))
+		if _, err = ArchiveVerify(path); err != nil {
+			return err
+		}
+	}
+
+	model, found, err := Sb.FindManifestContainer(sm)
+	if err != nil {
+		return err
+	}
+	running := found && Sb.GetServiceContainerStatus(model) == "running"
+	if running {
+		if !opts.Stop {
+			return fmt.Errorf("Service container %s is running; stop it before restoring", model.Name)
+		}
+		if err = Sb.ServiceDown(sm, out); err != nil {
+			return err
+		}
+		defer func() {
+			fmt.Fprintf(out, "Starting %s...\n", model.Name)
+			err = errors.Join(err, Sb.Client.ContainerStart(context.Background(), model.DockerId, container.StartOptions{}))
+		}()
+	}
+
+	a := ArchiverNew(ArchiveOptsNew(ArchiveRestoreSafetyName(target.Name, time.Now()), archivePath, target.Target, out))
+	defer a.Close()
+	if err = errors.Join(a.AddIncludes(target.Include...), a.AddExcludes(target.Exclude...)); err != nil {
+		return fmt.Errorf("%s: %w", target.Name, err)
+	}
+	if _, err = os.Stat(target.Target); err == nil {
+		if err = a.Archive(); err != nil && !errors.Is(err, NoFilesError) {
+			return err
+		}
+	}
+	count, err := ArchiveTargetClear(&a)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Cleared %d files from %s\n", count, target.Target)
+
+	for _, snapshot := range chain {
+		path := paths[snapshot.Archive]
+		count, err := ArchiveExtract(path, target.Target, target.Prefix, out)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Restored %d entries from %s into %s\n", count, path, target.Target)
+	}
+	if target.Incremental {
+		count, err := ArchiveSnapshotClean(&a, chain[len(chain)-1], out)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Removed %d files not in %s\n", count, file.Name)
+	}
+	return nil
+}
+
+// Verify the archives of a service, or of a single target
+// if `name` is given, reporting any that are corrupt.
+//
+// Only the most recent archive of each target is verified
+// unless `all` is set, in which case cataloged archives
+// no longer in storage are reported as well.
+//
+// Progress is only reported to `out` alongside a table, so
+// as not to break the other formats.
+func (Sb *ServiceBroker) VerifyArchives(sm manifest.ServiceManifest, name string, all bool, out io.Writer, format output.Format) error {
+	targets := sm.GetArchiveTargets()
+	if name != "" {
+		target, err := ArchiveTargetFind(sm, name)
+		if err != nil {
+			return err
+		}
+		targets = []manifest.ServiceManifestArchiveTarget{target}
+	}
+
+	archivePath := sm.GetArchiveDirectory()
+	storage, err := Sb.ArchiveStorage(sm)
+	if err != nil {
+		return err
+	}
+
+	progress := io.Discard
+	if format.Kind == output.KindTable || format.Kind == "" {
+		progress = out
+	}
+
+	var checks []ArchiveCheck
+	for _, target := range targets {
+		files, err := ArchiveFilesFind(storage, target.Name)
+		if err != nil {
+			return err
+		}
+		catalog, err := models.ArchiveCatalogFind(Sb.Database, models.ArchiveCatalogFindOpts{
+			Service: sm.Name,
+			Target:  target.Name,
+		})
+		if err != nil {
+			return err
+		}
+
+		stored := files
+		if !all && len(files) > 1 {
+			files = files[:1]
+		}
+		for _, file := range files {
+			fmt.Fprintf(progress, "Verifying %s...\n", file.Path)
+			path, cleanup, err := ArchiveFetch(storage, archivePath, file.Name)
+			if err != nil {
+				return err
+			}
+			checks = append(checks, ArchiveCheckFile(file, path, catalog))
+			cleanup()
+		}
+		if !all {
+			continue
+		}
+		for _, ac := range catalog {
+			found := slices.ContainsFunc(stored, func(f ArchiveFile) bool {
+				return f.Name == ac.Archive
+			})
+			if found {
+				continue
+			}
+			checks = append(checks, ArchiveCheck{
+				Archive: ac.Archive,
+				Target:  ac.Target,
+				Entries: ac.Files,
+				Size:    ac.Size,
+				Sha256:  ac.Sha256,
+				Status:  ArchiveCheckMissing,
+			})
+		}
+	}
+
+	if err := WriteArchiveChecks(out, checks, format); err != nil {
+		return err
+	}
+	failed := slices.DeleteFunc(slices.Clone(checks), func(c ArchiveCheck) bool {
+		return c.Status == ArchiveCheckOk
+	})
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d archives failed verification", len(failed), len(checks))
+	}
+	return nil
+}
+
+// Bring up services in dependency order.
+//
+// If any names are given, only those services and the
+// services they depend on are brought up.
+func (Sb *ServiceBroker) ServicesUp(names []string, out io.Writer) error {
+	manifests, err := Sb.Manifest.LoadServiceManifests()
+	if err != nil {
+		return err
+	}
+	order, err := manifest.ResolveServiceOrder(manifests, names...)
+	if err != nil {
+		return err
+	}
+
+	for _, sm := range order {
+		if err = Sb.ServiceUp(sm, out); err != nil {
+			return fmt.Errorf("%s: %w", sm.Name, err)
+		}
+	}
+	return nil
+}
+
+// Gracefully stop a single service container, if it
+// exists and is running.
+func (Sb *ServiceBroker) ServiceDown(sm manifest.ServiceManifest, out io.Writer) error {
+	model, found, err := Sb.FindManifestContainer(sm)
+	if err != nil || !found {
+		return err
+	}
+	return Sb.StopServiceContainer(model, sm, out)
+}
+
+// Run a command in a running service container, streaming
+// its output. Returns an error if the command exits with a
+// non-zero code.
+//
+// With `opts.Interactive`, `in` is passed to the command.
+func (Sb *ServiceBroker) ServiceExec(name string, command []string, opts ServiceExecOpts, in io.Reader, out, errOut io.Writer) error {
+	model, _, err := Sb.FindServiceContainer(name)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	exec, err := Sb.Client.ContainerExecCreate(ctx, model.DockerId, container.ExecOptions{
+		AttachStderr: true,
+		AttachStdin:  opts.Interactive,
+		AttachStdout: true,
+		Cmd:          command,
+		User:         opts.User,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := Sb.Client.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	if opts.Interactive {
+		go func() {
+			io.Copy(resp.Conn, in)
+			resp.CloseWrite()
+		}()
+	}
+	if _, err = stdcopy.StdCopy(out, errOut, resp.Reader); err != nil {
+		return err
+	}
+
+	inspect, err := Sb.Client.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return err
+	}
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("Command exited with code %d", inspect.ExitCode)
+	}
+	return nil
+}
+
+// Stream the logs of a service container.
+func (Sb *ServiceBroker) ServiceLogs(name string, opts ServiceLogsOpts, out, errOut io.Writer) error {
+	model, _, err := Sb.FindServiceContainer(name)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	resp, err := Sb.Client.ContainerInspect(ctx, model.DockerId)
+	if err != nil {
+		return err
+	}
+	logs, err := Sb.Client.ContainerLogs(ctx, model.DockerId, container.LogsOptions{
+		Follow:     opts.Follow,
+		ShowStderr: true,
+		ShowStdout: true,
+		Tail:       opts.Tail,
+		Timestamps: opts.Timestamps,
+	})
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	// Output of containers without a TTY is multiplexed.
+	if resp.Config != nil && resp.Config.Tty {
+		_, err = io.Copy(out, logs)
+	} else {
+		_, err = stdcopy.StdCopy(out, errOut, logs)
+	}
+	return err
+}
+
+// Remove a service container along with its record,
+// stopping it first if running.
+func (Sb *ServiceBroker) ServiceRemove(name string, out io.Writer) error {
+	model, sm, err := Sb.FindServiceContainer(name)
+	if err != nil {
+		return err
+	}
+	return Sb.RemoveServiceContainer(model, sm, out)
+}
+
+// Gracefully stop a service container, if running, and
+// start it again.
+func (Sb *ServiceBroker) ServiceRestart(name string, out io.Writer) error {
+	model, sm, err := Sb.FindServiceContainer(name)
+	if err != nil {
+		return err
+	}
+	if err = Sb.StopServiceContainer(model, sm, out); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Starting %s...\n", model.Name)
+	return Sb.Client.ContainerStart(context.Background(), model.DockerId, container.StartOptions{})
+}
+
+// Gracefully stop a service container, if running.
+func (Sb *ServiceBroker) ServiceStop(name string, out io.Writer) error {
+	model, sm, err := Sb.FindServiceContainer(name)
+	if err != nil {
+		return err
+	}
+	return Sb.StopServiceContainer(model, sm, out)
+}
+
+// Gracefully stop a service container, if running.
+func (Sb *ServiceBroker) StopServiceContainer(model models.ServiceContainer, sm manifest.ServiceManifest, out io.Writer) error {
+	args := WatchArgsShutdownNew(Sb.Client, WatchTarget{Manifest: sm, Model: model})
+	fmt.Fprintf(out, "Stopping %s...\n", model.Name)
+	return WatchShutdown(args)
+}
+
+// Stop services in reverse dependency order.
+//
+// If any names are given, only those services and the
+// services depending on them are stopped.
+func (Sb *ServiceBroker) ServicesDown(names []string, out io.Writer) error {
+	manifests, err := Sb.Manifest.LoadServiceManifests()
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		names = manifest.ServiceDependents(manifests, names...)
+	}
+	order, err := manifest.ResolveServiceOrder(manifests, names...)
+	if err != nil {
+		return err
+	}
+
+	for _, sm := range slices.Backward(order) {
+		if err = Sb.ServiceDown(sm, out); err != nil {
+			return fmt.Errorf("%s: %w", sm.Name, err)
+		}
+	}
+	return nil
+}
+
+// Join the <name>:<tag> references of image records.
+func imageRefs(images []models.ServiceImage) string {
+	refs := make([]string, 0, len(images))
+	for _, record := range images {
+		refs = append(refs, record.Name+":"+record.Tag)
+	}
+	return strings.Join(refs, ", ")
+}
+
+// Get the names, without tags, of the images built from a
+// manifest.
+func manifestImageNames(sm manifest.ServiceManifest) ([]string, error) {
+	var names []string
+	tags, err := sm.GetTags()
+	if err != nil {
+		return names, err
+	}
+	for _, tag := range tags {
+		name, _ := ImageReferenceSplit(tag)
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Get the name of the service container created from a
+// manifest.
+func manifestServiceName(sm manifest.ServiceManifest) string {
+	settings := sm.GetImageBuildSettings()
+	if settings.ServiceName == "" {
+		return sm.GetServiceName()
+	}
+	return settings.ServiceName
+}
+
 func attempt(sm manifest.ServiceManifest, callbacks ...ServiceManifestCallback) error {
 	var err error
 	for _, callback := range callbacks {
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"archive/tar"
 	"context"
 	"database/sql"
 	"io"
@@ -13,6 +14,49 @@ import (
 	"github.com/docker/docker/client"
 )
 
+// A file sent as part of an image build context.
+type BuildContextEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// Counts the bytes written to it.
+type byteCounter int64
+
+func (Bc *byteCounter) Write(p []byte) (int, error) {
+	*Bc += byteCounter(len(p))
+	return len(p), nil
+}
+
+// List the files of the image build context of a service,
+// along with the number of bytes the context is sent as.
+func BuildContextList(sm manifest.ServiceManifest) ([]BuildContextEntry, int64, error) {
+	var entries []BuildContextEntry
+	var size byteCounter
+	ctx, err := sm.GetImageBuildContext()
+	if err != nil {
+		return entries, 0, err
+	}
+	defer ctx.Close()
+
+	tr := tar.NewReader(io.TeeReader(ctx, &size))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return entries, int64(size), err
+		}
+		if header.Typeflag == tar.TypeReg {
+			entries = append(entries, BuildContextEntry{Path: header.Name, Size: header.Size})
+		}
+	}
+	// Padding past the end of the tar stream is sent too.
+	_, err = io.Copy(&size, ctx)
+	return entries, int64(size), err
+}
+
 // Attempt to build an image from an `ImageManifest`.
 func BuildImageFromManifest(cli *client.Client, sm manifest.ServiceManifest) ([]models.ServiceImage, error) {
 	var sModels []models.ServiceImage
@@ -28,12 +72,19 @@ func BuildImageFromManifest(cli *client.Client, sm manifest.ServiceManifest) ([]
 	defer ctx.Close()
 
 	settings := sm.GetImageBuildSettings()
+	handle, err := BuildEventWriterNew(settings.OutDestination, settings.OutFormat)
+	if err != nil {
+		return sModels, err
+	}
 	resp, err := cli.ImageBuild(context.Background(), ctx, opt)
 	if err != nil {
 		return sModels, err
 	}
 	defer resp.Body.Close()
-	io.Copy(settings.OutDestination, resp.Body)
+	imageId, err := BuildEventsRead(resp.Body, handle)
+	if err != nil {
+		return sModels, err
+	}
 
 	_, err = cli.ImagesPrune(context.Background(), filters.Args{})
 	if err != nil {
@@ -41,24 +92,11 @@ func BuildImageFromManifest(cli *client.Client, sm manifest.ServiceManifest) ([]
 	}
 
 	for _, tag := range opt.Tags {
-		tParts := strings.SplitN(tag, ":", 2)
-		tName := tParts[0]
-		tTag := "latest"
-		if len(tParts) > 1 {
-			tTag = tParts[1]
-		}
-
-		resp, err := cli.ImageList(context.Background(), image.ListOptions{
-			Filters: filters.NewArgs(filters.Arg("reference", tag)),
-		})
-		if err != nil {
-			return sModels, err
-		}
-
+		tName, tTag := ImageReferenceSplit(tag)
 		model_opts := models.ServiceImageNewOptions{
 			Name:     tName,
 			Tag:      tTag,
-			DockerId: resp[0].ID,
+			DockerId: imageId,
 		}
 		model, err := models.ServiceImageNew(model_opts)
 		if err != nil {
@@ -74,6 +112,42 @@ func BuildImageFromManifest(cli *client.Client, sm manifest.ServiceManifest) ([]
 	return sModels, err
 }
 
+// Check whether the container image a service container
+// would be created from exists.
+func ImageExistsFromManifest(cli *client.Client, sm manifest.ServiceManifest) (bool, error) {
+	ref, err := ImageReferenceFromManifest(sm)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := cli.ImageList(context.Background(), image.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("reference", ref)),
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(resp) > 0, nil
+}
+
+// Get the image, as <name>:<tag>, a service container
+// would be created from.
+func ImageReferenceFromManifest(sm manifest.ServiceManifest) (string, error) {
+	config, err := sm.GetServiceBuildConfig(sm.GetImageBuildSettings().TagName)
+	return config.Image, err
+}
+
+// Split an image reference into its name and tag. Without
+// a tag, the tag is "latest".
+func ImageReferenceSplit(ref string) (string, string) {
+	// Only a colon after the last slash separates the tag,
+	// as registry hosts may have a port.
+	idx := strings.LastIndex(ref, ":")
+	if idx < 0 || strings.Contains(ref[idx:], "/") {
+		return ref, "latest"
+	}
+	return ref[:idx], ref[idx+1:]
+}
+
 // Attempt to create a service container from an
 // `ImageManifest`.
 //
@@ -95,17 +169,23 @@ func BuildServiceFromManifest(cli *client.Client, sm manifest.ServiceManifest) (
 	if err != nil {
 		return model, err
 	}
+	netc := sm.GetServiceNetworkConfig()
 
 	ctx := context.Background()
 	res, err := cli.ContainerCreate(
 		ctx,
 		&config,
 		&hostc,
-		nil, nil, settings.ServiceName)
+		&netc,
+		nil, settings.ServiceName)
+	if err != nil {
+		return model, err
+	}
 
 	model_opts := models.ServiceContainerNewOpts{
 		Name:     settings.ServiceName,
 		DockerId: res.ID,
+		Manifest: sm.GetManifestPath(),
 	}
 	model, err = models.ServiceContainerNew(model_opts)
 	if err != nil {
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const (
+	BuildEventAux    = "aux"
+	BuildEventError  = "error"
+	BuildEventStatus = "status"
+	BuildEventStep   = "step"
+	BuildEventStream = "stream"
+)
+
+const (
+	BuildLogJson = "json"
+	BuildLogText = "text"
+)
+
+// Returned when the daemon reports an image build failed.
+var ImageBuildError = fmt.Errorf("Image build failed")
+
+var buildStepRegex = regexp.MustCompile(`^Step (\d+/\d+) : `)
+
+// A progress event decoded from the output of an image
+// build.
+type BuildEvent struct {
+	Kind string `json:"kind"`
+	// Position of a step, as <step>/<steps>.
+	Step string `json:"step,omitempty"`
+	Text string `json:"text,omitempty"`
+	// ID of the built image, only set on aux events.
+	ImageId string `json:"image_id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (Be BuildEvent) String() string {
+	switch Be.Kind {
+	case BuildEventAux:
+		return "Built image " + Be.ImageId
+	case BuildEventError:
+		return "Error: " + Be.Error
+	default:
+		return Be.Text
+	}
+}
+
+// A message of the stream the daemon writes image build
+// output as.
+type buildMessage struct {
+	Aux         json.RawMessage `json:"aux"`
+	Error       string          `json:"error"`
+	ErrorDetail *struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+	Id       string `json:"id"`
+	Progress string `json:"progress"`
+	Status   string `json:"status"`
+	Stream   string `json:"stream"`
+}
+
+// Decode the events of a message.
+func (Bm buildMessage) events() ([]BuildEvent, error) {
+	var events []BuildEvent
+	if Bm.ErrorDetail != nil || Bm.Error != "" {
+		message := Bm.Error
+		if Bm.ErrorDetail != nil && Bm.ErrorDetail.Message != "" {
+			message = Bm.ErrorDetail.Message
+		}
+		return append(events, BuildEvent{Kind: BuildEventError, Error: message}), nil
+	}
+
+	// Aux messages with an ID carry builder specific data,
+	// such as traces, rather than the image built.
+	if len(Bm.Aux) > 0 && (Bm.Id == "" || Bm.Id == "moby.image.id") {
+		var aux struct {
+			ID string
+		}
+		if err := json.Unmarshal(Bm.Aux, &aux); err != nil {
+			return events, err
+		}
+		if aux.ID != "" {
+			events = append(events, BuildEvent{Kind: BuildEventAux, ImageId: aux.ID})
+		}
+	}
+
+	if Bm.Status != "" {
+		text := strings.TrimSpace(Bm.Status + " " + Bm.Progress)
+		if Bm.Id != "" {
+			text = Bm.Id + ": " + text
+		}
+		events = append(events, BuildEvent{Kind: BuildEventStatus, Text: text})
+	}
+
+	for line := range strings.Lines(Bm.Stream) {
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		event := BuildEvent{Kind: BuildEventStream, Text: line}
+		if match := buildStepRegex.FindStringSubmatch(line); match != nil {
+			event.Kind = BuildEventStep
+			event.Step = match[1]
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Decode the output of an image build from `r`, passing
+// each event to `handle`. Returns the ID of the image
+// built, or an error if the build failed.
+func BuildEventsRead(r io.Reader, handle func(BuildEvent) error) (string, error) {
+	var imageId string
+	var failure error
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var message buildMessage
+		err := dec.Decode(&message)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imageId, fmt.Errorf("Unreadable image build output: %w", err)
+		}
+
+		events, err := message.events()
+		if err != nil {
+			return imageId, fmt.Errorf("Unreadable image build output: %w", err)
+		}
+		for _, event := range events {
+			switch event.Kind {
+			case BuildEventAux:
+				imageId = event.ImageId
+			case BuildEventError:
+				failure = errors.Join(failure, fmt.Errorf("%w: %s", ImageBuildError, event.Error))
+			}
+			if err = handle(event); err != nil {
+				return imageId, err
+			}
+		}
+	}
+
+	if failure != nil {
+		return imageId, failure
+	}
+	if imageId == "" {
+		return imageId, fmt.Errorf("%w: no image ID was reported", ImageBuildError)
+	}
+	return imageId, nil
+}
+
+// Create a handler writing build events to `out`, either as
+// logs or, with format "json", one JSON object per line.
+func BuildEventWriterNew(out io.Writer, format string) (func(BuildEvent) error, error) {
+	switch format {
+	case BuildLogJson:
+		enc := json.NewEncoder(out)
+		enc.SetEscapeHTML(false)
+		return func(event BuildEvent) error {
+			return enc.Encode(event)
+		}, nil
+	case BuildLogText, "":
+		return func(event BuildEvent) error {
+			// Failures are returned, and reported, by the
+			// caller instead.
+			if event.Kind == BuildEventError {
+				return nil
+			}
+			_, err := fmt.Fprintln(out, event)
+			return err
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unknown build log format '%s'; expected text or json", format)
+	}
+}
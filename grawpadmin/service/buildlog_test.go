@@ -0,0 +1,155 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBuildEventsRead(t *testing.T) {
+	const imageId = "sha256:4f1f4d3b0b8a"
+	tests := []struct {
+		name       string
+		stream     string
+		wantEvents []BuildEvent
+		wantId     string
+		wantErr    error
+	}{
+		{
+			name: "stream and aux",
+			stream: `{"stream":"Step 1/2 : FROM alpine\n"}
+{"stream":" ---> 9234e8fb04c4\n"}
+{"stream":"Step 2/2 : RUN true\n"}
+{"aux":{"ID":"` + imageId + `"}}
+{"stream":"Successfully built 4f1f4d3b0b8a\n"}
+`,
+			wantEvents: []BuildEvent{
+				{Kind: BuildEventStep, Step: "1/2", Text: "Step 1/2 : FROM alpine"},
+				{Kind: BuildEventStream, Text: " ---> 9234e8fb04c4"},
+				{Kind: BuildEventStep, Step: "2/2", Text: "Step 2/2 : RUN true"},
+				{Kind: BuildEventAux, ImageId: imageId},
+				{Kind: BuildEventStream, Text: "Successfully built 4f1f4d3b0b8a"},
+			},
+			wantId: imageId,
+		},
+		{
+			name: "multiple lines in a stream",
+			stream: `{"stream":"first\r\n\nsecond\n"}
+{"aux":{"ID":"` + imageId + `"}}`,
+			wantEvents: []BuildEvent{
+				{Kind: BuildEventStream, Text: "first"},
+				{Kind: BuildEventStream, Text: "second"},
+				{Kind: BuildEventAux, ImageId: imageId},
+			},
+			wantId: imageId,
+		},
+		{
+			name: "status",
+			stream: `{"status":"Downloading","progress":"[==>  ] 1MB/2MB","id":"a1b2c3"}
+{"status":"Pull complete"}
+{"aux":{"ID":"` + imageId + `"}}`,
+			wantEvents: []BuildEvent{
+				{Kind: BuildEventStatus, Text: "a1b2c3: Downloading [==>  ] 1MB/2MB"},
+				{Kind: BuildEventStatus, Text: "Pull complete"},
+				{Kind: BuildEventAux, ImageId: imageId},
+			},
+			wantId: imageId,
+		},
+		{
+			name: "builder aux ignored",
+			stream: `{"id":"moby.buildkit.trace","aux":"dHJhY2U="}
+{"id":"moby.image.id","aux":{"ID":"` + imageId + `"}}`,
+			wantEvents: []BuildEvent{
+				{Kind: BuildEventAux, ImageId: imageId},
+			},
+			wantId: imageId,
+		},
+		{
+			name: "error detail",
+			stream: `{"stream":"Step 1/1 : RUN false\n"}
+{"errorDetail":{"code":1,"message":"The command '/bin/sh -c false' returned a non-zero code: 1"},"error":"The command '/bin/sh -c false' returned a non-zero code: 1"}
+`,
+			wantEvents: []BuildEvent{
+				{Kind: BuildEventStep, Step: "1/1", Text: "Step 1/1 : RUN false"},
+				{Kind: BuildEventError, Error: "The command '/bin/sh -c false' returned a non-zero code: 1"},
+			},
+			wantErr: ImageBuildError,
+		},
+		{
+			name:   "error detail after the image ID",
+			stream: `{"aux":{"ID":"` + imageId + `"}}{"errorDetail":{"message":"failed to tag"}}`,
+			wantEvents: []BuildEvent{
+				{Kind: BuildEventAux, ImageId: imageId},
+				{Kind: BuildEventError, Error: "failed to tag"},
+			},
+			wantId:  imageId,
+			wantErr: ImageBuildError,
+		},
+		{
+			name:   "no image ID",
+			stream: `{"stream":"Step 1/1 : FROM alpine\n"}`,
+			wantEvents: []BuildEvent{
+				{Kind: BuildEventStep, Step: "1/1", Text: "Step 1/1 : FROM alpine"},
+			},
+			wantErr: ImageBuildError,
+		},
+		{
+			name:    "empty",
+			wantErr: ImageBuildError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []BuildEvent
+			id, err := BuildEventsRead(strings.NewReader(tt.stream), func(event BuildEvent) error {
+				events = append(events, event)
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("BuildEventsRead() error = %v, want %v", err, tt.wantErr)
+			}
+			if id != tt.wantId {
+				t.Errorf("BuildEventsRead() = %q, want %q", id, tt.wantId)
+			}
+			if !slices.Equal(events, tt.wantEvents) {
+				t.Errorf("events = %+v, want %+v", events, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestBuildEventsReadInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+	}{
+		{name: "not json", stream: "Step 1/1 : FROM alpine\n"},
+		{name: "truncated", stream: `{"stream":"Step 1/1`},
+		{name: "invalid aux", stream: `{"aux":"not an object"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := BuildEventsRead(strings.NewReader(tt.stream), func(BuildEvent) error { return nil })
+			if err == nil || !strings.Contains(err.Error(), "Unreadable image build output") {
+				t.Errorf("BuildEventsRead() error = %v, want unreadable output", err)
+			}
+		})
+	}
+}
+
+// An error handling an event stops reading.
+func TestBuildEventsReadHandleError(t *testing.T) {
+	stop := errors.New("stop")
+	var count int
+	_, err := BuildEventsRead(strings.NewReader(`{"stream":"a\n"}{"stream":"b\n"}`), func(BuildEvent) error {
+		count++
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("BuildEventsRead() error = %v, want %v", err, stop)
+	}
+	if count != 1 {
+		t.Errorf("handled %d events, want 1", count)
+	}
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Size of the blocks compressed concurrently by gzip.
+const gzipBlockSize = 1 << 20
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Create a writer compressing into `w` as described by
+// `compression`. Closing it does not close `w`.
+func ArchiveCompressorNew(w io.Writer, compression manifest.ServiceManifestCompression) (io.WriteCloser, error) {
+	format, err := compression.GetFormat()
+	if err != nil {
+		return nil, err
+	}
+	threads := int(compression.Threads)
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
+
+	switch format {
+	case manifest.ArchiveCompressionGzip:
+		level := compression.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		if level < gzip.DefaultCompression || level > gzip.BestCompression {
+			return nil, fmt.Errorf("Invalid gzip level %d; expected 1 to 9", level)
+		}
+		if threads == 1 {
+			return gzip.NewWriterLevel(w, level)
+		}
+		return parallelGzipWriterNew(w, level, threads), nil
+	case manifest.ArchiveCompressionZstd:
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(threads)}
+		if compression.Level != 0 {
+			if compression.Level < 1 || compression.Level > 22 {
+				return nil, fmt.Errorf("Invalid zstd level %d; expected 1 to 22", compression.Level)
+			}
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(compression.Level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+// Create a reader decompressing `r`, detecting the format
+// from its magic bytes. Anything neither gzip nor zstd is
+// read as is.
+func ArchiveDecompressorNew(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Compresses blocks concurrently, each as its own gzip
+// member. Readers decompress the members as one stream.
+//
+// Blocks, buffers and compressors are reused, so memory
+// is bound by the number of threads rather than by how
+// much is written.
+type parallelGzipWriter struct {
+	block   []byte
+	blocks  chan []byte
+	buffers chan *bytes.Buffer
+	done    chan struct{}
+	err     error
+	flushed bool
+	level   int
+	lock    sync.Mutex
+	queue   chan chan parallelGzipBlock
+	w       io.Writer
+	writers chan *gzip.Writer
+}
+
+type parallelGzipBlock struct {
+	data *bytes.Buffer
+	err  error
+}
+
+func (P *parallelGzipWriter) Close() error {
+	// An empty stream still needs a member to be valid.
+	if len(P.block) > 0 || !P.flushed {
+		P.flush()
+	}
+	close(P.queue)
+	<-P.done
+	return P.loadErr()
+}
+
+func (P *parallelGzipWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if err := P.loadErr(); err != nil {
+			return 0, err
+		}
+		take := min(gzipBlockSize-len(P.block), len(p))
+		P.block = append(P.block, p[:take]...)
+		p = p[take:]
+		if len(P.block) == gzipBlockSize {
+			P.flush()
+		}
+	}
+	return n, nil
+}
+
+// Compress the current block. Blocks once as many blocks
+// as there are threads are waiting to be written.
+func (P *parallelGzipWriter) flush() {
+	block := P.block
+	P.block = P.takeBlock()
+	P.flushed = true
+	result := make(chan parallelGzipBlock, 1)
+	P.queue <- result
+
+	go func() {
+		buf := P.takeBuffer()
+		gz, err := P.takeWriter(buf)
+		if err == nil {
+			_, err = gz.Write(block)
+			err = errors.Join(err, gz.Close())
+			freeGive(P.writers, gz)
+		}
+		freeGive(P.blocks, block)
+		result <- parallelGzipBlock{data: buf, err: err}
+	}()
+}
+
+func (P *parallelGzipWriter) takeBlock() []byte {
+	if block, ok := freeTake(P.blocks); ok {
+		return block[:0]
+	}
+	return make([]byte, 0, gzipBlockSize)
+}
+
+func (P *parallelGzipWriter) takeBuffer() *bytes.Buffer {
+	if buf, ok := freeTake(P.buffers); ok {
+		buf.Reset()
+		return buf
+	}
+	return &bytes.Buffer{}
+}
+
+func (P *parallelGzipWriter) takeWriter(w io.Writer) (*gzip.Writer, error) {
+	if gz, ok := freeTake(P.writers); ok {
+		gz.Reset(w)
+		return gz, nil
+	}
+	return gzip.NewWriterLevel(w, P.level)
+}
+
+func (P *parallelGzipWriter) loadErr() error {
+	P.lock.Lock()
+	defer P.lock.Unlock()
+	return P.err
+}
+
+func (P *parallelGzipWriter) storeErr(err error) {
+	P.lock.Lock()
+	defer P.lock.Unlock()
+	if P.err == nil {
+		P.err = err
+	}
+}
+
+// Write compressed blocks out in the order they were
+// queued.
+func (P *parallelGzipWriter) writeLoop() {
+	defer close(P.done)
+	for result := range P.queue {
+		block := <-result
+		if P.loadErr() != nil {
+			continue
+		}
+		if block.err != nil {
+			P.storeErr(block.err)
+			continue
+		}
+		if _, err := P.w.Write(block.data.Bytes()); err != nil {
+			P.storeErr(err)
+		}
+		freeGive(P.buffers, block.data)
+	}
+}
+
+func parallelGzipWriterNew(w io.Writer, level, threads int) *parallelGzipWriter {
+	// Blocks are in use while queued, being compressed and
+	// being filled.
+	inUse := threads + 2
+	P := &parallelGzipWriter{
+		block:   make([]byte, 0, gzipBlockSize),
+		blocks:  make(chan []byte, inUse),
+		buffers: make(chan *bytes.Buffer, inUse),
+		done:    make(chan struct{}),
+		level:   level,
+		queue:   make(chan chan parallelGzipBlock, threads),
+		w:       w,
+		writers: make(chan *gzip.Writer, inUse),
+	}
+	go P.writeLoop()
+	return P
+}
+
+// Keep `value` in `free` to be reused, dropping it if
+// enough are kept already.
+func freeGive[T any](free chan T, value T) {
+	select {
+	case free <- value:
+	default:
+	}
+}
+
+// Take a value kept in `free`, if any.
+func freeTake[T any](free chan T) (T, bool) {
+	select {
+	case value := <-free:
+		return value, true
+	default:
+		var zero T
+		return zero, false
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+	"github.com/WilkinsonK/grawp/grawpadmin/service/models"
+	"github.com/docker/docker/client"
+)
+
+// How long the server may take to flush the world to disk.
+const archiveFlushTimeout = 5 * time.Minute
+
+// Resumes a service container held for an archive. Safe to
+// call more than once.
+type ArchiveResume func() error
+
+// Keep a running service container from writing to its
+// volume until the returned `ArchiveResume` is called.
+//
+// If holding the container fails part way, whatever was
+// done is undone before returning.
+func ArchiveHold(cli *client.Client, model models.ServiceContainer, sm manifest.ServiceManifest, consistency string, out io.Writer) (ArchiveResume, error) {
+	var resume func() error
+	var err error
+
+	switch consistency {
+	case manifest.ArchiveConsistencyNone:
+		return func() error { return nil }, nil
+	case manifest.ArchiveConsistencyPause:
+		fmt.Fprintf(out, "Pausing %s...\n", model.Name)
+		resume, err = archiveHoldPause(cli, model, out)
+	case manifest.ArchiveConsistencyRcon:
+		fmt.Fprintf(out, "Turning off saving for %s...\n", model.Name)
+		resume, err = archiveHoldRcon(sm, out)
+	default:
+		return nil, fmt.Errorf("Unknown archive consistency '%s'", consistency)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sync.OnceValue(resume), nil
+}
+
+func archiveHoldPause(cli *client.Client, model models.ServiceContainer, out io.Writer) (func() error, error) {
+	if err := cli.ContainerPause(context.Background(), model.DockerId); err != nil {
+		return nil, err
+	}
+	return func() error {
+		fmt.Fprintf(out, "Unpausing %s...\n", model.Name)
+		return cli.ContainerUnpause(context.Background(), model.DockerId)
+	}, nil
+}
+
+func archiveHoldRcon(sm manifest.ServiceManifest, out io.Writer) (func() error, error) {
+	client, err := RconDialFromManifest(sm)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	// The archive may take long enough for the connection
+	// to be dropped, so saving is turned back on over a
+	// new one.
+	resume := func() error {
+		fmt.Fprintf(out, "Turning on saving for %s...\n", sm.Name)
+		client, err := RconDialFromManifest(sm)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		_, err = client.Command("save-on")
+		return err
+	}
+
+	if _, err = client.Command("save-off"); err != nil {
+		return nil, errors.Join(err, resume())
+	}
+	client.Timeout = archiveFlushTimeout
+	if _, err = client.Command("save-all flush"); err != nil {
+		return nil, errors.Join(err, resume())
+	}
+	return resume, nil
+}
+
+// Resume a held service container if interrupted before
+// `done` is closed, then exit.
+func ArchiveResumeOnSignal(resume ArchiveResume, cleanup func(), done <-chan struct{}) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		defer signal.Stop(sigChan)
+		select {
+		case <-sigChan:
+			cleanup()
+			if err := resume(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			}
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// A gitignore-like pattern matched against paths relative
+// to the root of an archive.
+//
+// A leading "!" negates the pattern, and a trailing "/"
+// only matches directories. Patterns with a "/" anywhere
+// but at their end are anchored to the root; others match
+// at any depth. A "**" segment matches any number of
+// directories. A pattern matching a directory matches
+// everything below it as well.
+type GlobPattern struct {
+	DirOnly  bool
+	Negate   bool
+	Pattern  string
+	segments []string
+}
+
+// Whether the pattern matches `rel`, a slash separated
+// relative path, or any directory containing it.
+func (Gp *GlobPattern) Match(rel string, isDir bool) bool {
+	parts := strings.Split(rel, "/")
+	for i := len(parts); i > 0; i-- {
+		if Gp.DirOnly && i == len(parts) && !isDir {
+			continue
+		}
+		if globMatchSegments(Gp.segments, parts[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (Gp GlobPattern) String() string {
+	return Gp.Pattern
+}
+
+// Patterns applied in order, later patterns overriding
+// earlier ones.
+type GlobPatterns []GlobPattern
+
+// Whether `rel` is matched by the last pattern matching
+// it. A negated pattern unmatches what came before.
+//
+// As with gitignore, nothing below a matched directory can
+// be unmatched again, as the directory is never looked
+// into. Unlike gitignore, a negated pattern matching a
+// directory unmatches everything below it until matched by
+// a later pattern, and patterns starting with a negated
+// pattern match anything not otherwise unmatched.
+func (Gps GlobPatterns) Match(rel string, isDir bool) bool {
+	if len(Gps) == 0 {
+		return false
+	}
+	if dir := path.Dir(rel); dir != "." && Gps.MatchDirectory(dir) {
+		return true
+	}
+	matched, found := Gps.matchLast(rel, isDir)
+	if !found {
+		return Gps[0].Negate
+	}
+	return matched
+}
+
+// Whether the directory `rel`, or any directory containing
+// it, is matched by a pattern rather than only by default.
+// Nothing below such a directory can be unmatched.
+func (Gps GlobPatterns) MatchDirectory(rel string) bool {
+	parts := strings.Split(rel, "/")
+	for i := 1; i <= len(parts); i++ {
+		if matched, _ := Gps.matchLast(strings.Join(parts[:i], "/"), true); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Find the last pattern matching `rel`, returning whether
+// it is not negated and whether any pattern matched.
+func (Gps GlobPatterns) matchLast(rel string, isDir bool) (bool, bool) {
+	for _, gp := range slices.Backward(Gps) {
+		if gp.Match(rel, isDir) {
+			return !gp.Negate, true
+		}
+	}
+	return false, false
+}
+
+// Parse a gitignore-like pattern. See `GlobPattern`.
+func GlobPatternParse(pattern string) (GlobPattern, error) {
+	gp := GlobPattern{Pattern: pattern}
+	p := pattern
+	if strings.HasPrefix(p, "!") {
+		gp.Negate = true
+		p = p[1:]
+	} else if strings.HasPrefix(p, `\!`) {
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		gp.DirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(strings.TrimPrefix(p, "./"), "/")
+	for part := range strings.SplitSeq(p, "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		if part != "**" {
+			if _, err := path.Match(part, ""); err != nil {
+				return gp, fmt.Errorf("Invalid glob pattern '%s': %w", pattern, err)
+			}
+		}
+		gp.segments = append(gp.segments, part)
+	}
+	if len(gp.segments) == 0 {
+		return gp, fmt.Errorf("Invalid glob pattern '%s': matches nothing", pattern)
+	}
+	if !anchored {
+		gp.segments = append([]string{"**"}, gp.segments...)
+	}
+	return gp, nil
+}
+
+// Parse each of `patterns`. See `GlobPattern`.
+func GlobPatternsParse(patterns ...string) (GlobPatterns, error) {
+	var gps GlobPatterns
+	for _, pattern := range patterns {
+		gp, err := GlobPatternParse(pattern)
+		if err != nil {
+			return gps, err
+		}
+		gps = append(gps, gp)
+	}
+	return gps, nil
+}
+
+// Match path segments against pattern segments. A "**"
+// segment matches any number of path segments, though a
+// trailing "**" matches at least one.
+func globMatchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				return len(parts) > 0
+			}
+			for i := range len(parts) + 1 {
+				if globMatchSegments(pattern, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
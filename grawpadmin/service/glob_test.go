@@ -0,0 +1,167 @@
+package service
+
+import "testing"
+
+func TestGlobPatternMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		// Leading "**".
+		{"leading any depth", "**/*.json", "a/b/c.json", false, true},
+		{"leading at root", "**/*.json", "c.json", false, true},
+		{"leading other extension", "**/*.json", "a/c.yml", false, false},
+
+		// Middle "**".
+		{"middle no directories", "world/**/region", "world/region", true, true},
+		{"middle one directory", "world/**/region", "world/DIM1/region", true, true},
+		{"middle several directories", "world/**/region", "world/a/b/region", true, true},
+		{"middle file under match", "world/**/region", "world/a/region/r.0.0.mca", false, true},
+		{"middle other root", "world/**/region", "nether/a/region", true, false},
+
+		// Trailing "**".
+		{"trailing file", "world/**", "world/level.dat", false, true},
+		{"trailing nested", "world/**", "world/a/b/c", false, true},
+		{"trailing not the directory itself", "world/**", "world", true, false},
+		{"trailing with segment", "world/*/**", "world/region/r.0.0.mca", false, true},
+		{"trailing with segment too shallow", "world/*/**", "world/level.dat", false, false},
+
+		// Anchoring.
+		{"unanchored at root", "*.log", "latest.log", false, true},
+		{"unanchored nested", "*.log", "logs/latest.log", false, true},
+		{"anchored at root", "logs/*.log", "logs/latest.log", false, true},
+		{"anchored nested", "logs/*.log", "a/logs/latest.log", false, false},
+		{"leading slash anchors", "/latest.log", "logs/latest.log", false, false},
+		{"leading slash at root", "/latest.log", "latest.log", false, true},
+
+		// Directories match everything below them.
+		{"directory contents", "cache", "cache/a/b", false, true},
+		{"prefix is not a directory", "cache", "cached/a", false, false},
+
+		// Trailing "/".
+		{"dir only directory", "logs/", "logs", true, true},
+		{"dir only file", "logs/", "logs", false, false},
+		{"dir only file below", "logs/", "logs/latest.log", false, true},
+		{"dir only nested", "logs/", "a/logs", true, true},
+		{"dir only anchored", "a/logs/", "b/logs", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gp, err := GlobPatternParse(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := gp.Match(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("%q.Match(%q, %v) = %v, want %v", tt.pattern, tt.path, tt.isDir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlobPatternsMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		isDir    bool
+		want     bool
+	}{
+		{"none", nil, "a", false, false},
+		{"negated", []string{"*.log", "!debug.log"}, "debug.log", false, false},
+		{"negated other", []string{"*.log", "!debug.log"}, "latest.log", false, true},
+		{"last wins", []string{"!debug.log", "*.log"}, "debug.log", false, true},
+		{"leading negation matches rest", []string{"!*.log"}, "level.dat", false, true},
+		{"leading negation unmatches", []string{"!*.log"}, "latest.log", false, false},
+		// As with gitignore, nothing below a matched
+		// directory can be re-included.
+		{"no reinclude under excluded directory", []string{"logs/", "!logs/keep.txt"}, "logs/keep.txt", false, true},
+		{"no reinclude deep under excluded directory", []string{"logs", "!**/keep.txt"}, "logs/a/keep.txt", false, true},
+		{"reinclude of the directory", []string{"logs/", "!logs/"}, "logs/keep.txt", false, false},
+		{"negated directory", []string{"*.log", "!keep/"}, "keep/a.log", false, false},
+		{"leading negation of a directory", []string{"!world/"}, "world/level.dat", false, false},
+		{"leading negation below a directory", []string{"!*.json"}, "config/a.json", false, false},
+		{"excluded directory sibling", []string{"logs/", "!logs/keep.txt"}, "logs/latest.log", false, true},
+		{"excluded directory itself", []string{"logs/", "!logs/keep.txt"}, "logs", true, true},
+		{"escaped bang", []string{`\!important`}, "!important", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gps, err := GlobPatternsParse(tt.patterns...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := gps.Match(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("%q.Match(%q, %v) = %v, want %v", tt.patterns, tt.path, tt.isDir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlobPatternsMatchDirectory(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		dir      string
+		want     bool
+	}{
+		{"matched", []string{"logs/"}, "logs", true},
+		{"below matched", []string{"logs/"}, "logs/old", true},
+		{"other", []string{"logs/"}, "world", false},
+		{"negated after", []string{"logs/", "!logs/"}, "logs", false},
+		{"negated file below", []string{"logs/", "!logs/keep.txt"}, "logs", true},
+		// Directories only matched by default are still
+		// looked into.
+		{"leading negation", []string{"!*.json"}, "config", false},
+		{"leading negation matched", []string{"!*.json", "cache/"}, "cache", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gps, err := GlobPatternsParse(tt.patterns...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := gps.MatchDirectory(tt.dir); got != tt.want {
+				t.Errorf("%q.MatchDirectory(%q) = %v, want %v", tt.patterns, tt.dir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlobPatternParse(t *testing.T) {
+	tests := []struct {
+		pattern string
+		dirOnly bool
+		negate  bool
+		wantErr bool
+	}{
+		{pattern: "*.json"},
+		{pattern: "logs/", dirOnly: true},
+		{pattern: "!logs/", dirOnly: true, negate: true},
+		{pattern: "!*.log", negate: true},
+		{pattern: `\!*.log`},
+		{pattern: "[", wantErr: true},
+		{pattern: "/", wantErr: true},
+		{pattern: "./", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			gp, err := GlobPatternParse(tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GlobPatternParse(%q) error = %v, want error %v", tt.pattern, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if gp.DirOnly != tt.dirOnly || gp.Negate != tt.negate {
+				t.Errorf("GlobPatternParse(%q) = dir only %v, negate %v; want %v, %v",
+					tt.pattern, gp.DirOnly, gp.Negate, tt.dirOnly, tt.negate)
+			}
+			if gp.String() != tt.pattern {
+				t.Errorf("String() = %q, want %q", gp.String(), tt.pattern)
+			}
+		})
+	}
+}
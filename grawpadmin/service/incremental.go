@@ -0,0 +1,209 @@
+package service
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+	"github.com/WilkinsonK/grawp/grawpadmin/service/models"
+)
+
+// Tracks the files of an incremental archive against the
+// snapshot of the archive before it.
+type ArchiveIndex struct {
+	Files    []models.ArchiveSnapshotFile
+	added    uint
+	previous map[string]models.ArchiveSnapshotFile
+}
+
+// Record a file written to the archive.
+func (Ai *ArchiveIndex) Add(path string, info fs.FileInfo, sum string) {
+	Ai.added++
+	Ai.Files = append(Ai.Files, models.ArchiveSnapshotFile{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Sha256:  sum,
+	})
+}
+
+// Whether any file was added, changed or removed since
+// the previous snapshot.
+func (Ai *ArchiveIndex) Changed() bool {
+	return Ai.added > 0 || len(Ai.Files) != len(Ai.previous)
+}
+
+// Check if a file is unchanged since the previous
+// snapshot, recording it if so.
+//
+// Files with a different modification time, but the same
+// size, are hashed to tell whether they changed.
+func (Ai *ArchiveIndex) Unchanged(path, rel string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	prev, ok := Ai.previous[rel]
+	if !ok || prev.Size != info.Size() {
+		return false, nil
+	}
+
+	if !prev.ModTime.Equal(info.ModTime()) {
+		sum, err := fileSha256(path)
+		if err != nil || sum != prev.Sha256 {
+			return false, err
+		}
+		prev.ModTime = info.ModTime()
+	}
+	Ai.Files = append(Ai.Files, prev)
+	return true, nil
+}
+
+// Create an index compared against the files of the
+// previous snapshot. Without any, every file is added.
+func ArchiveIndexNew(previous []models.ArchiveSnapshotFile) *ArchiveIndex {
+	index := ArchiveIndex{previous: make(map[string]models.ArchiveSnapshotFile)}
+	for _, file := range previous {
+		index.previous[file.Path] = file
+	}
+	return &index
+}
+
+// Get the archives a snapshot is reconstructed from, from
+// its full archive through to itself.
+func ArchiveSnapshotChain(snapshots []models.ArchiveSnapshot, archive string) ([]models.ArchiveSnapshot, error) {
+	var chain []models.ArchiveSnapshot
+	for name := archive; name != ""; {
+		idx := slices.IndexFunc(snapshots, func(s models.ArchiveSnapshot) bool {
+			return s.Archive == name
+		})
+		if idx < 0 {
+			if len(chain) == 0 {
+				return nil, fmt.Errorf("No snapshot recorded for archive %s", archive)
+			}
+			return nil, fmt.Errorf("Archive %s is missing its parent %s", chain[len(chain)-1].Archive, name)
+		}
+		if len(chain) > len(snapshots) {
+			return nil, fmt.Errorf("Snapshots of archive %s form a cycle", archive)
+		}
+		chain = append(chain, snapshots[idx])
+		name = snapshots[idx].Parent
+	}
+	slices.Reverse(chain)
+	return chain, nil
+}
+
+// Find the snapshot the next archive of an incremental
+// target is a delta of. Returns false if a full archive is
+// due instead.
+//
+// A full archive is made once `FullEvery` deltas have been
+// made since the last one, or if any archive needed to
+// reconstruct the previous snapshot is missing from
+// `files`.
+func ArchiveSnapshotPrevious(snapshots []models.ArchiveSnapshot, target manifest.ServiceManifestArchiveTarget, files []ArchiveFile) (models.ArchiveSnapshot, bool) {
+	// An archive made earlier the same day is written over
+	// and so cannot be the previous snapshot.
+	ext, _ := target.Compression.GetExtension()
+	fullName := ArchiveFileName(target.Name, target.TargetDate(), ext)
+	deltaName := ArchiveDeltaFileName(target.Name, target.TargetDate(), ext)
+	snapshots = slices.DeleteFunc(slices.Clone(snapshots), func(s models.ArchiveSnapshot) bool {
+		return s.Archive == deltaName
+	})
+	if len(snapshots) == 0 {
+		return models.ArchiveSnapshot{}, false
+	}
+
+	chain, err := ArchiveSnapshotChain(snapshots, snapshots[0].Archive)
+	if err != nil {
+		return models.ArchiveSnapshot{}, false
+	}
+	for _, snapshot := range chain {
+		stored := slices.ContainsFunc(files, func(f ArchiveFile) bool {
+			return f.Name == snapshot.Archive
+		})
+		if !stored {
+			return models.ArchiveSnapshot{}, false
+		}
+	}
+
+	// Writing over the full archive of today would break
+	// the deltas already made from it.
+	if uint(len(chain)-1) < target.GetFullEvery() || chain[0].Archive == fullName {
+		return snapshots[0], true
+	}
+	return models.ArchiveSnapshot{}, false
+}
+
+// Remove files covered by the archiver which are not part
+// of a snapshot, returning the number removed. Only regular
+// files are recorded in snapshots, so only those are
+// removed.
+func ArchiveSnapshotClean(a *Archiver, snapshot models.ArchiveSnapshot, out io.Writer) (uint, error) {
+	var count uint
+	kept := make(map[string]bool)
+	for _, file := range snapshot.Files {
+		kept[file.Path] = true
+	}
+
+	err := filepath.WalkDir(a.Options.RootPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || !a.PathIsAllowed(path, false) || kept[a.RelativePath(path)] {
+			return err
+		}
+		if err = os.Remove(path); err != nil {
+			return err
+		}
+		count++
+		fmt.Fprintln(out, "Removed: ", path)
+		return nil
+	})
+	return count, err
+}
+
+// Find the archives of an incremental target, dated by
+// when their snapshots were taken.
+func ArchiveSnapshotFiles(db *sql.DB, service string, target manifest.ServiceManifestArchiveTarget, storage ArchiveStorage) ([]ArchiveFile, []models.ArchiveSnapshot, error) {
+	files, err := ArchiveFilesFind(storage, target.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshots, err := models.ArchiveSnapshotFind(db, models.ArchiveSnapshotFindOpts{
+		Service: service,
+		Target:  target.Name,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, file := range files {
+		idx := slices.IndexFunc(snapshots, func(s models.ArchiveSnapshot) bool {
+			return s.Archive == file.Name
+		})
+		if idx >= 0 {
+			files[i].Date = snapshots[idx].Date
+		}
+	}
+	ArchiveFilesSort(files)
+	return files, snapshots, nil
+}
+
+func fileSha256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
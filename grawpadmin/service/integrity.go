@@ -0,0 +1,226 @@
+package service
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/WilkinsonK/grawp/grawpadmin/output"
+	"github.com/WilkinsonK/grawp/grawpadmin/service/models"
+	"github.com/docker/go-units"
+)
+
+// Name of the archive entry listing the files an archive
+// holds. It is written last and never extracted.
+const ArchiveContentsName = ".grawp-contents.json"
+
+const (
+	ArchiveCheckCorrupt = "corrupt"
+	ArchiveCheckMissing = "missing"
+	ArchiveCheckOk      = "ok"
+)
+
+// The file list embedded in an archive.
+type ArchiveContents struct {
+	Files []models.ArchiveSnapshotFile `json:"files"`
+}
+
+// What was read back from an archive while verifying it.
+type ArchiveDigest struct {
+	// Number of entries, not counting the file list.
+	Entries uint
+	// Whether the archive embeds a file list.
+	Listed bool
+	Sha256 string
+	Size   int64
+}
+
+// The outcome of verifying a single archive.
+type ArchiveCheck struct {
+	Archive string `json:"archive"`
+	Target  string `json:"target"`
+	Entries uint   `json:"entries"`
+	Size    int64  `json:"size"`
+	Sha256  string `json:"sha256,omitempty"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Verify an archive file, read from the local `path`,
+// comparing it against its entry in the archive catalog if
+// there is one.
+func ArchiveCheckFile(file ArchiveFile, path string, catalog []models.ArchiveCatalog) ArchiveCheck {
+	check := ArchiveCheck{Archive: file.Name, Target: file.Target, Size: file.Size, Status: ArchiveCheckOk}
+	digest, err := ArchiveVerify(path)
+	check.Entries = digest.Entries
+	check.Sha256 = digest.Sha256
+
+	idx := slices.IndexFunc(catalog, func(ac models.ArchiveCatalog) bool {
+		return ac.Archive == file.Name
+	})
+	if err == nil && idx >= 0 && catalog[idx].Sha256 != digest.Sha256 {
+		err = fmt.Errorf("Archive %s does not match the checksum it was cataloged with", file.Path)
+	}
+	if err != nil {
+		check.Status = ArchiveCheckCorrupt
+		check.Error = err.Error()
+	}
+	return check
+}
+
+// Get the path of the file holding the checksum of an
+// archive.
+func ArchiveChecksumName(path string) string {
+	return path + ".sha256"
+}
+
+// Read the checksum of an archive. Returns an empty string
+// if the archive has no checksum file.
+func ArchiveChecksumRead(path string) (string, error) {
+	data, err := os.ReadFile(ArchiveChecksumName(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	sum, _, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	return sum, nil
+}
+
+// Write the checksum of an archive in the format read by
+// `sha256sum --check`.
+func ArchiveChecksumWrite(path, sum string) error {
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	return os.WriteFile(ArchiveChecksumName(path), []byte(line), defaultFileMode)
+}
+
+// Write the file list of an archive as its last entry.
+func ArchiveContentsWrite(tw *tar.Writer, files []models.ArchiveSnapshotFile) error {
+	data, err := json.Marshal(ArchiveContents{Files: files})
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Name:     ArchiveContentsName,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}
+	if err = tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// Read an archive through to its end, checking its
+// entries against its embedded file list and the archive
+// against its checksum file.
+//
+// Archives written before either existed are only checked
+// for being readable. Checksums of the compressed stream
+// are only validated once the whole stream has been read.
+func ArchiveVerify(path string) (ArchiveDigest, error) {
+	var digest ArchiveDigest
+	corrupt := func(err error) (ArchiveDigest, error) {
+		return digest, fmt.Errorf("Archive %s is corrupt: %w", path, err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return digest, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	r := io.TeeReader(file, hash)
+	dr, err := ArchiveDecompressorNew(r)
+	if err != nil {
+		return corrupt(err)
+	}
+	defer dr.Close()
+
+	var contents ArchiveContents
+	sums := make(map[string]string)
+	tr := tar.NewReader(dr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return corrupt(err)
+		}
+		if header.Name == ArchiveContentsName {
+			if err = json.NewDecoder(tr).Decode(&contents); err != nil {
+				return corrupt(fmt.Errorf("unreadable file list: %w", err))
+			}
+			digest.Listed = true
+			continue
+		}
+
+		entry := sha256.New()
+		if _, err = io.Copy(entry, tr); err != nil {
+			return corrupt(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			sums[header.Name] = hex.EncodeToString(entry.Sum(nil))
+		}
+		digest.Entries++
+	}
+	// Trailing data past the end of the tar stream must
+	// still be read for the checksum to be checked.
+	if _, err = io.Copy(io.Discard, dr); err != nil {
+		return corrupt(err)
+	}
+	if _, err = io.Copy(io.Discard, r); err != nil {
+		return digest, err
+	}
+	if info, err := file.Stat(); err == nil {
+		digest.Size = info.Size()
+	}
+	digest.Sha256 = hex.EncodeToString(hash.Sum(nil))
+
+	for _, listed := range contents.Files {
+		sum, ok := sums[listed.Path]
+		if !ok {
+			return corrupt(fmt.Errorf("%s is listed but missing", listed.Path))
+		}
+		if sum != listed.Sha256 {
+			return corrupt(fmt.Errorf("%s does not match its listed checksum", listed.Path))
+		}
+	}
+
+	expected, err := ArchiveChecksumRead(path)
+	if err != nil {
+		return digest, err
+	}
+	if expected != "" && expected != digest.Sha256 {
+		return corrupt(fmt.Errorf("checksum does not match %s", ArchiveChecksumName(path)))
+	}
+	return digest, nil
+}
+
+// Write the outcome of verifying archives.
+func WriteArchiveChecks(out io.Writer, checks []ArchiveCheck, format output.Format) error {
+	return output.Write(out, format, checks, []output.Column[ArchiveCheck]{
+		{Header: "TARGET", Value: func(c ArchiveCheck) string { return c.Target }},
+		{Header: "ARCHIVE", Value: func(c ArchiveCheck) string { return c.Archive }},
+		{Header: "ENTRIES", Value: func(c ArchiveCheck) string { return fmt.Sprint(c.Entries) }},
+		{Header: "SIZE", Value: func(c ArchiveCheck) string { return units.BytesSize(float64(c.Size)) }},
+		{Header: "STATUS", Value: func(c ArchiveCheck) string { return c.Status }},
+		{Header: "ERROR", Value: func(c ArchiveCheck) string { return c.Error }},
+	})
+}
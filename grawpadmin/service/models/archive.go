@@ -0,0 +1,219 @@
+package models
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ArchiveSnapshotKindDelta = "delta"
+	ArchiveSnapshotKindFull  = "full"
+)
+
+// A file as it was when a snapshot was taken.
+type ArchiveSnapshotFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Sha256  string    `json:"sha256"`
+}
+
+// Records the files of an archive target at the time one
+// of its incremental archives was made.
+//
+// Full archives hold every file. Delta archives hold only
+// the files changed since their parent.
+type ArchiveSnapshot struct {
+	Uuid    uuid.UUID             `json:"uuid"`
+	Service string                `json:"service"`
+	Target  string                `json:"target"`
+	Archive string                `json:"archive"`
+	Parent  string                `json:"parent,omitempty"`
+	Kind    string                `json:"kind"`
+	Date    time.Time             `json:"date"`
+	Files   []ArchiveSnapshotFile `json:"files"`
+}
+
+func (as *ArchiveSnapshot) Scan(value any) error {
+	return json.Unmarshal([]byte(value.(string)), as)
+}
+
+func (as *ArchiveSnapshot) Value() (driver.Value, error) {
+	b, err := json.Marshal(as)
+	return string(b), err
+}
+
+func ArchiveSnapshotAdd(db *sql.DB, as ...ArchiveSnapshot) (int, error) {
+	stmt, err := db.Prepare("INSERT INTO archive_snapshot(archivesnapshot) VALUES(?)")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var count int = 0
+	var buffer driver.Value
+	for _, model := range as {
+		buffer, err = model.Value()
+		if err != nil {
+			return count, err
+		}
+		_, err = stmt.Exec(buffer)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+func ArchiveSnapshotDel(db *sql.DB, as ...ArchiveSnapshot) (int, error) {
+	stmt, err := db.Prepare("DELETE FROM archive_snapshot WHERE archivesnapshot->>'uuid' = ?")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var count int = 0
+	for _, model := range as {
+		_, err = stmt.Exec(model.Uuid.String())
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+type ArchiveSnapshotFindOpts struct {
+	Service string
+	Target  string
+	Archive string
+	Limit   uint
+}
+
+// Find archive snapshots, most recent first.
+func ArchiveSnapshotFind(db *sql.DB, opts ArchiveSnapshotFindOpts) ([]ArchiveSnapshot, error) {
+	var buf strings.Builder
+	var ass []ArchiveSnapshot
+	var args []any
+	buf.WriteString("SELECT archivesnapshot FROM archive_snapshot")
+
+	var cond []string
+	if opts.Service != "" {
+		cond = append(cond, "archivesnapshot->>'service' = ?")
+		args = append(args, opts.Service)
+	}
+	if opts.Target != "" {
+		cond = append(cond, "archivesnapshot->>'target' = ?")
+		args = append(args, opts.Target)
+	}
+	if opts.Archive != "" {
+		cond = append(cond, "archivesnapshot->>'archive' = ?")
+		args = append(args, opts.Archive)
+	}
+
+	if len(cond) > 0 {
+		buf.WriteString(" WHERE ")
+		buf.WriteString(strings.Join(cond, " AND "))
+	}
+	buf.WriteString(" ORDER BY unixepoch(archivesnapshot->>'date', 'subsec') DESC")
+
+	if opts.Limit != 0 {
+		buf.WriteString(" LIMIT ?")
+		args = append(args, opts.Limit)
+	}
+
+	stmt, err := db.Prepare(buf.String())
+	if err != nil {
+		return ass, err
+	}
+	defer stmt.Close()
+
+	resp, err := stmt.Query(args...)
+	if err != nil {
+		return ass, err
+	}
+	defer resp.Close()
+
+	for resp.Next() {
+		var as ArchiveSnapshot
+		err = resp.Scan(&as)
+		if err != nil {
+			return ass, err
+		}
+		ass = append(ass, as)
+	}
+
+	return ass, resp.Err()
+}
+
+// Add snapshots, replacing any already recorded for the
+// same archive.
+func ArchiveSnapshotPut(db *sql.DB, as ...ArchiveSnapshot) (int, error) {
+	var count int = 0
+	for _, model := range as {
+		found, err := ArchiveSnapshotFind(db, ArchiveSnapshotFindOpts{
+			Service: model.Service,
+			Target:  model.Target,
+			Archive: model.Archive,
+		})
+		if err != nil {
+			return count, err
+		}
+		if _, err = ArchiveSnapshotDel(db, found...); err != nil {
+			return count, err
+		}
+		if _, err = ArchiveSnapshotAdd(db, model); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func ArchiveSnapshotTableInit(db *sql.DB) error {
+	return createServiceModelTable(db, ServiceModelOpts{
+		TableName: "archive_snapshot",
+		ModelName: "archivesnapshot",
+	})
+}
+
+type ArchiveSnapshotNewOpts struct {
+	Uuid    uuid.UUID
+	Service string
+	Target  string
+	Archive string
+	Parent  string
+	Date    time.Time
+	Files   []ArchiveSnapshotFile
+}
+
+// Create a new `ArchiveSnapshot` model. Snapshots without
+// a parent are full snapshots.
+func ArchiveSnapshotNew(opts ArchiveSnapshotNewOpts) (ArchiveSnapshot, error) {
+	var as ArchiveSnapshot
+	err := validateUuidOrGenerateNewUuid(&opts.Uuid)
+	if err != nil {
+		return as, err
+	}
+
+	as.Uuid = opts.Uuid
+	as.Service = opts.Service
+	as.Target = opts.Target
+	as.Archive = opts.Archive
+	as.Parent = opts.Parent
+	as.Kind = ArchiveSnapshotKindFull
+	if opts.Parent != "" {
+		as.Kind = ArchiveSnapshotKindDelta
+	}
+	as.Date = opts.Date
+	as.Files = opts.Files
+	return as, nil
+}
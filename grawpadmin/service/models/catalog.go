@@ -0,0 +1,200 @@
+package models
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Records an archive written for an archive target, along
+// with what it should hold when read back.
+type ArchiveCatalog struct {
+	Uuid    uuid.UUID `json:"uuid"`
+	Service string    `json:"service"`
+	Target  string    `json:"target"`
+	Archive string    `json:"archive"`
+	Date    time.Time `json:"date"`
+	Size    int64     `json:"size"`
+	Files   uint      `json:"files"`
+	Sha256  string    `json:"sha256"`
+}
+
+func (ac *ArchiveCatalog) Scan(value any) error {
+	return json.Unmarshal([]byte(value.(string)), ac)
+}
+
+func (ac *ArchiveCatalog) Value() (driver.Value, error) {
+	b, err := json.Marshal(ac)
+	return string(b), err
+}
+
+func ArchiveCatalogAdd(db *sql.DB, acs ...ArchiveCatalog) (int, error) {
+	stmt, err := db.Prepare("INSERT INTO archive_catalog(archivecatalog) VALUES(?)")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var count int = 0
+	var buffer driver.Value
+	for _, model := range acs {
+		buffer, err = model.Value()
+		if err != nil {
+			return count, err
+		}
+		_, err = stmt.Exec(buffer)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+func ArchiveCatalogDel(db *sql.DB, acs ...ArchiveCatalog) (int, error) {
+	stmt, err := db.Prepare("DELETE FROM archive_catalog WHERE archivecatalog->>'uuid' = ?")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var count int = 0
+	for _, model := range acs {
+		_, err = stmt.Exec(model.Uuid.String())
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+type ArchiveCatalogFindOpts struct {
+	Service string
+	Target  string
+	Archive string
+	Limit   uint
+}
+
+// Find cataloged archives, most recent first.
+func ArchiveCatalogFind(db *sql.DB, opts ArchiveCatalogFindOpts) ([]ArchiveCatalog, error) {
+	var buf strings.Builder
+	var acs []ArchiveCatalog
+	var args []any
+	buf.WriteString("SELECT archivecatalog FROM archive_catalog")
+
+	var cond []string
+	if opts.Service != "" {
+		cond = append(cond, "archivecatalog->>'service' = ?")
+		args = append(args, opts.Service)
+	}
+	if opts.Target != "" {
+		cond = append(cond, "archivecatalog->>'target' = ?")
+		args = append(args, opts.Target)
+	}
+	if opts.Archive != "" {
+		cond = append(cond, "archivecatalog->>'archive' = ?")
+		args = append(args, opts.Archive)
+	}
+
+	if len(cond) > 0 {
+		buf.WriteString(" WHERE ")
+		buf.WriteString(strings.Join(cond, " AND "))
+	}
+	buf.WriteString(" ORDER BY unixepoch(archivecatalog->>'date', 'subsec') DESC")
+
+	if opts.Limit != 0 {
+		buf.WriteString(" LIMIT ?")
+		args = append(args, opts.Limit)
+	}
+
+	stmt, err := db.Prepare(buf.String())
+	if err != nil {
+		return acs, err
+	}
+	defer stmt.Close()
+
+	resp, err := stmt.Query(args...)
+	if err != nil {
+		return acs, err
+	}
+	defer resp.Close()
+
+	for resp.Next() {
+		var ac ArchiveCatalog
+		err = resp.Scan(&ac)
+		if err != nil {
+			return acs, err
+		}
+		acs = append(acs, ac)
+	}
+
+	return acs, resp.Err()
+}
+
+// Add cataloged archives, replacing any already recorded
+// for the same archive.
+func ArchiveCatalogPut(db *sql.DB, acs ...ArchiveCatalog) (int, error) {
+	var count int = 0
+	for _, model := range acs {
+		found, err := ArchiveCatalogFind(db, ArchiveCatalogFindOpts{
+			Service: model.Service,
+			Target:  model.Target,
+			Archive: model.Archive,
+		})
+		if err != nil {
+			return count, err
+		}
+		if _, err = ArchiveCatalogDel(db, found...); err != nil {
+			return count, err
+		}
+		if _, err = ArchiveCatalogAdd(db, model); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func ArchiveCatalogTableInit(db *sql.DB) error {
+	return createServiceModelTable(db, ServiceModelOpts{
+		TableName: "archive_catalog",
+		ModelName: "archivecatalog",
+	})
+}
+
+type ArchiveCatalogNewOpts struct {
+	Uuid    uuid.UUID
+	Service string
+	Target  string
+	Archive string
+	Date    time.Time
+	Size    int64
+	Files   uint
+	Sha256  string
+}
+
+// Create a new `ArchiveCatalog` model.
+func ArchiveCatalogNew(opts ArchiveCatalogNewOpts) (ArchiveCatalog, error) {
+	var ac ArchiveCatalog
+	err := validateUuidOrGenerateNewUuid(&opts.Uuid)
+	if err != nil {
+		return ac, err
+	}
+
+	ac.Uuid = opts.Uuid
+	ac.Service = opts.Service
+	ac.Target = opts.Target
+	ac.Archive = opts.Archive
+	ac.Date = opts.Date
+	ac.Size = opts.Size
+	ac.Files = opts.Files
+	ac.Sha256 = opts.Sha256
+	return ac, nil
+}
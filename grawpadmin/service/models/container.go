@@ -15,6 +15,7 @@ type ServiceContainer struct {
 	Name        string    `json:"name"`
 	DockerId    string    `json:"docker_id"`
 	IsAvailable bool      `json:"is_available"`
+	Manifest    string    `json:"manifest"`
 }
 
 func (sc *ServiceContainer) Scan(value any) error {
@@ -59,13 +60,13 @@ func ServiceContainerAdd(db *sql.DB, sc ...ServiceContainer) (int, error) {
 func ServiceContainerDel(db *sql.DB, sc ...ServiceContainer) (int, error) {
 	stmt, err := db.Prepare("delete from service_container where servicecontainer->>'uuid' = ?")
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
 	defer stmt.Close()
 
 	var count int = 0
 	for _, model := range sc {
-		_, err = stmt.Exec(&model)
+		_, err = stmt.Exec(model.Uuid)
 		if err != nil {
 			return count, err
 		}
@@ -210,6 +211,7 @@ type ServiceContainerNewOpts struct {
 	Uuid     uuid.UUID
 	Name     string
 	DockerId string
+	Manifest string
 }
 
 // Create a new `ServiceContainer` model.
@@ -223,6 +225,7 @@ func ServiceContainerNew(opts ServiceContainerNewOpts) (ServiceContainer, error)
 	sc.Uuid = opts.Uuid
 	sc.Name = opts.Name
 	sc.DockerId = opts.DockerId
+	sc.Manifest = opts.Manifest
 	sc.IsAvailable = true
 	return sc, nil
 }
@@ -68,13 +68,13 @@ func ServiceImageAdd(db *sql.DB, si ...ServiceImage) (int, error) {
 func ServiceImageDel(db *sql.DB, si ...ServiceImage) (int, error) {
 	stmt, err := db.Prepare("delete from service_image where serviceimage->>'uuid' = ?")
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
 	defer stmt.Close()
 
 	var count int = 0
 	for _, image := range si {
-		_, err = stmt.Exec(&image)
+		_, err = stmt.Exec(image.Uuid)
 		if err != nil {
 			return count, err
 		}
@@ -50,6 +50,12 @@ func InitDatabaseTables(db *sql.DB) error {
 	if err := ServiceContainerTableInit(db); err != nil {
 		return err
 	}
+	if err := ArchiveSnapshotTableInit(db); err != nil {
+		return err
+	}
+	if err := ArchiveCatalogTableInit(db); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"slices"
+
+	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// Create a user-defined network, reusing it if a network
+// of the same name already exists.
+//
+// Returns the network ID.
+func NetworkEnsure(cli *client.Client, definition manifest.GrawpManifestNetwork) (string, error) {
+	ctx := context.Background()
+	found, err := cli.NetworkList(ctx, network.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("name", definition.Name)),
+	})
+	if err != nil {
+		return "", err
+	}
+	// The name filter also matches partial names.
+	for _, n := range found {
+		if n.Name == definition.Name {
+			return n.ID, nil
+		}
+	}
+
+	resp, err := cli.NetworkCreate(ctx, definition.Name, network.CreateOptions{
+		Driver:   definition.Driver,
+		Internal: definition.Internal,
+		Options:  definition.Options,
+		Labels:   map[string]string{"grawp": "true"},
+	})
+	return resp.ID, err
+}
+
+// Compute the network values of a service from the other
+// services sharing a network with it.
+func NetworkValuesFromManifests(sm manifest.ServiceManifest, manifests []manifest.ServiceManifest) manifest.ServiceManifestNetworkValues {
+	values := manifest.ServiceManifestNetworkValues{
+		Services: make(map[string]string),
+	}
+	for _, n := range sm.GetNetworks() {
+		values.Names = append(values.Names, n.Name)
+	}
+
+	for _, other := range manifests {
+		if other.Name == sm.Name {
+			continue
+		}
+		shared := slices.ContainsFunc(other.GetNetworks(), func(n manifest.ServiceManifestNetwork) bool {
+			return slices.Contains(values.Names, n.Name)
+		})
+		if !shared {
+			continue
+		}
+		if address, err := other.GetNetworkAddress(); err == nil {
+			values.Services[other.Name] = address
+		}
+	}
+	return values
+}
@@ -99,6 +99,9 @@ func GenerateServiceFile(opts ServiceNewOpts) error {
 	fmt.Fprintf(file, "# The volume mount from the host filesystem. Volume\n")
 	fmt.Fprintf(file, "# mounts from the container point to /opt/.\n")
 	fmt.Fprintf(file, "local-volume: %s\n", opts.LocalVolume)
+	fmt.Fprintf(file, "# The container port players connect to. Services on a\n")
+	fmt.Fprintf(file, "# shared network reach one another by it.\n")
+	fmt.Fprintf(file, "game-port: \"25565\"\n")
 	fmt.Fprintf(file, "ports:\n")
 	fmt.Fprintf(file, "# Properties can be any arbitrary value. Like Args,\n")
 	fmt.Fprintf(file, "# except that they are not used at build time.\n")
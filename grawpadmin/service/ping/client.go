@@ -0,0 +1,233 @@
+// Implements the Minecraft Server List Ping protocol used
+// by clients to query the status of a server.
+package ping
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PacketIdHandshake int32 = 0x00
+	PacketIdStatus    int32 = 0x00
+	PacketIdPing      int32 = 0x01
+)
+
+// Protocol version sent in the handshake. Servers answer
+// status requests regardless of the version given.
+const ProtocolVersion int32 = -1
+
+// Handshake state requesting the server status.
+const nextStateStatus int32 = 1
+
+// Limit on the size of a single packet read from a server.
+const maxPacketSize = 1 << 21
+
+// A chat component, as used by the server description.
+type Chat struct {
+	Text  string `json:"text"`
+	Extra []Chat `json:"extra,omitempty"`
+}
+
+// Flatten the chat component into plain text.
+func (c Chat) String() string {
+	var buf strings.Builder
+	buf.WriteString(c.Text)
+	for _, extra := range c.Extra {
+		buf.WriteString(extra.String())
+	}
+	return buf.String()
+}
+
+// Chat components may be plain strings or objects.
+func (c *Chat) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		c.Text = text
+		return nil
+	}
+
+	type chat Chat
+	return json.Unmarshal(data, (*chat)(c))
+}
+
+type StatusPlayer struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type StatusPlayers struct {
+	Max    int            `json:"max"`
+	Online int            `json:"online"`
+	Sample []StatusPlayer `json:"sample,omitempty"`
+}
+
+type StatusVersion struct {
+	Name     string `json:"name"`
+	Protocol int    `json:"protocol"`
+}
+
+// The status a server reports in response to a ping.
+type Status struct {
+	Description Chat          `json:"description"`
+	Favicon     string        `json:"favicon,omitempty"`
+	Latency     time.Duration `json:"-"`
+	Players     StatusPlayers `json:"players"`
+	Version     StatusVersion `json:"version"`
+}
+
+// Read a length prefixed packet, returning its id and
+// payload.
+func ReadPacket(r *bufio.Reader) (int32, []byte, error) {
+	size, err := ReadVarInt(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if size <= 0 || size > maxPacketSize {
+		return 0, nil, fmt.Errorf("ping: invalid packet size %d", size)
+	}
+
+	data := make([]byte, size)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	body := bufio.NewReader(bytes.NewReader(data))
+	id, err := ReadVarInt(body)
+	if err != nil {
+		return 0, nil, err
+	}
+	payload, _ := io.ReadAll(body)
+	return id, payload, nil
+}
+
+// Read a length prefixed UTF-8 string.
+func ReadString(r *bufio.Reader) (string, error) {
+	size, err := ReadVarInt(r)
+	if err != nil {
+		return "", err
+	}
+	if size < 0 || size > maxPacketSize {
+		return "", fmt.Errorf("ping: invalid string size %d", size)
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	return string(data), err
+}
+
+// Read a variable length integer.
+func ReadVarInt(r io.ByteReader) (int32, error) {
+	var value uint32
+	for shift := 0; shift < 35; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint32(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return int32(value), nil
+		}
+	}
+	return 0, fmt.Errorf("ping: varint is too big")
+}
+
+// Write a length prefixed packet.
+func WritePacket(w io.Writer, id int32, payload []byte) error {
+	var body bytes.Buffer
+	WriteVarInt(&body, id)
+	body.Write(payload)
+
+	var buf bytes.Buffer
+	WriteVarInt(&buf, int32(body.Len()))
+	buf.Write(body.Bytes())
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Write a length prefixed UTF-8 string.
+func WriteString(buf *bytes.Buffer, value string) {
+	WriteVarInt(buf, int32(len(value)))
+	buf.WriteString(value)
+}
+
+// Write a variable length integer.
+func WriteVarInt(buf *bytes.Buffer, value int32) {
+	v := uint32(value)
+	for v >= 0x80 {
+		buf.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	buf.WriteByte(byte(v))
+}
+
+// Query the status of the server at `address`.
+//
+// The whole exchange, including connecting, must complete
+// within `timeout`.
+func Ping(address string, timeout time.Duration) (Status, error) {
+	var status Status
+	host, portS, err := net.SplitHostPort(address)
+	if err != nil {
+		return status, err
+	}
+	port, err := strconv.ParseUint(portS, 10, 16)
+	if err != nil {
+		return status, err
+	}
+
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return status, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var handshake bytes.Buffer
+	WriteVarInt(&handshake, ProtocolVersion)
+	WriteString(&handshake, host)
+	binary.Write(&handshake, binary.BigEndian, uint16(port))
+	WriteVarInt(&handshake, nextStateStatus)
+	if err = WritePacket(conn, PacketIdHandshake, handshake.Bytes()); err != nil {
+		return status, err
+	}
+	if err = WritePacket(conn, PacketIdStatus, nil); err != nil {
+		return status, err
+	}
+
+	r := bufio.NewReader(conn)
+	id, payload, err := ReadPacket(r)
+	if err != nil {
+		return status, err
+	}
+	if id != PacketIdStatus {
+		return status, fmt.Errorf("ping: unexpected packet id %#x", id)
+	}
+	data, err := ReadString(bufio.NewReader(bytes.NewReader(payload)))
+	if err != nil {
+		return status, err
+	}
+	if err = json.Unmarshal([]byte(data), &status); err != nil {
+		return status, err
+	}
+
+	// Measure the round trip of a ping. Some servers close
+	// the connection instead of answering; the status is
+	// still valid in that case.
+	sent := time.Now()
+	var pingPayload bytes.Buffer
+	binary.Write(&pingPayload, binary.BigEndian, sent.UnixMilli())
+	if err = WritePacket(conn, PacketIdPing, pingPayload.Bytes()); err != nil {
+		return status, nil
+	}
+	if id, _, err = ReadPacket(r); err == nil && id == PacketIdPing {
+		status.Latency = time.Since(sent)
+	}
+	return status, nil
+}
@@ -0,0 +1,229 @@
+package ping
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// An in-process status server answering with `status`.
+// Pongs are delayed by `delay`; without `pong` the
+// connection is closed after the status instead.
+type fakeServer struct {
+	delay    time.Duration
+	listener net.Listener
+	pong     bool
+	status   string
+	errs     chan error
+}
+
+func (F *fakeServer) Addr() string {
+	return F.listener.Addr().String()
+}
+
+// Check the packets the client sent were framed correctly.
+func (F *fakeServer) Err() error {
+	return <-F.errs
+}
+
+func (F *fakeServer) serve() {
+	conn, err := F.listener.Accept()
+	if err != nil {
+		F.errs <- err
+		return
+	}
+	defer conn.Close()
+	F.errs <- F.handle(conn)
+}
+
+func (F *fakeServer) handle(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	id, payload, err := ReadPacket(r)
+	if err != nil {
+		return err
+	}
+	if id != PacketIdHandshake {
+		return fmt.Errorf("handshake packet id %#x, want %#x", id, PacketIdHandshake)
+	}
+	body := bufio.NewReader(bytes.NewReader(payload))
+	version, err := ReadVarInt(body)
+	if err != nil {
+		return err
+	}
+	host, err := ReadString(body)
+	if err != nil {
+		return err
+	}
+	var port uint16
+	if err = binary.Read(body, binary.BigEndian, &port); err != nil {
+		return err
+	}
+	state, err := ReadVarInt(body)
+	if err != nil {
+		return err
+	}
+	addr := F.listener.Addr().(*net.TCPAddr)
+	if version != ProtocolVersion || host != addr.IP.String() || int(port) != addr.Port || state != nextStateStatus {
+		return fmt.Errorf("handshake = %d %s:%d %d, want %d %s:%d %d",
+			version, host, port, state, ProtocolVersion, addr.IP, addr.Port, nextStateStatus)
+	}
+	if body.Buffered() > 0 {
+		return fmt.Errorf("handshake has %d trailing bytes", body.Buffered())
+	}
+
+	id, payload, err = ReadPacket(r)
+	if err != nil {
+		return err
+	}
+	if id != PacketIdStatus || len(payload) != 0 {
+		return fmt.Errorf("status request = %#x %x, want %#x with no payload", id, payload, PacketIdStatus)
+	}
+	var response bytes.Buffer
+	WriteString(&response, F.status)
+	if err = WritePacket(conn, PacketIdStatus, response.Bytes()); err != nil {
+		return err
+	}
+
+	if !F.pong {
+		return nil
+	}
+	id, payload, err = ReadPacket(r)
+	if err != nil {
+		return err
+	}
+	if id != PacketIdPing || len(payload) != 8 {
+		return fmt.Errorf("ping = %#x %x, want %#x with 8 bytes", id, payload, PacketIdPing)
+	}
+	time.Sleep(F.delay)
+	return WritePacket(conn, PacketIdPing, payload)
+}
+
+func fakeServerNew(t *testing.T, status string, pong bool, delay time.Duration) *fakeServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	F := &fakeServer{
+		delay:    delay,
+		listener: listener,
+		pong:     pong,
+		status:   status,
+		errs:     make(chan error, 1),
+	}
+	go F.serve()
+	return F
+}
+
+func TestVarIntRoundTrip(t *testing.T) {
+	tests := []struct {
+		value int32
+		size  int
+	}{
+		{0, 1},
+		{1, 1},
+		{127, 1},
+		{128, 2},
+		{255, 2},
+		{25565, 3},
+		{2097151, 3},
+		{2147483647, 5},
+		{-1, 5},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(int(tt.value)), func(t *testing.T) {
+			var buf bytes.Buffer
+			WriteVarInt(&buf, tt.value)
+			if buf.Len() != tt.size {
+				t.Errorf("WriteVarInt(%d) wrote %d bytes, want %d", tt.value, buf.Len(), tt.size)
+			}
+			got, err := ReadVarInt(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.value {
+				t.Errorf("ReadVarInt() = %d, want %d", got, tt.value)
+			}
+		})
+	}
+}
+
+func TestPing(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		description string
+	}{
+		{
+			name:        "plain description",
+			status:      `{"description":"A Minecraft Server","players":{"max":20,"online":1,"sample":[{"id":"0","name":"grawp"}]},"version":{"name":"1.21.4","protocol":769}}`,
+			description: "A Minecraft Server",
+		},
+		{
+			name:        "chat description",
+			status:      `{"description":{"text":"A ","extra":[{"text":"Minecraft"},{"text":" Server","extra":["!"]}]},"players":{"max":20,"online":1,"sample":[{"id":"0","name":"grawp"}]},"version":{"name":"1.21.4","protocol":769}}`,
+			description: "A Minecraft Server!",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fakeServerNew(t, tt.status, true, 0)
+			status, err := Ping(server.Addr(), time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = server.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if got := status.Description.String(); got != tt.description {
+				t.Errorf("Description = %q, want %q", got, tt.description)
+			}
+			if status.Players.Online != 1 || status.Players.Max != 20 || len(status.Players.Sample) != 1 {
+				t.Errorf("Players = %+v, want 1 of 20 online", status.Players)
+			}
+			if status.Version.Name != "1.21.4" || status.Version.Protocol != 769 {
+				t.Errorf("Version = %+v, want 1.21.4 (769)", status.Version)
+			}
+		})
+	}
+}
+
+func TestPingLatency(t *testing.T) {
+	delay := 20 * time.Millisecond
+	server := fakeServerNew(t, `{"description":""}`, true, delay)
+	status, err := Ping(server.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = server.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if status.Latency < delay {
+		t.Errorf("Latency = %v, want at least %v", status.Latency, delay)
+	}
+}
+
+// Servers closing the connection instead of answering the
+// ping still report their status.
+func TestPingNoPong(t *testing.T) {
+	server := fakeServerNew(t, `{"description":"motd"}`, false, 0)
+	status, err := Ping(server.Addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = server.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if status.Description.String() != "motd" {
+		t.Errorf("Description = %q, want %q", status.Description.String(), "motd")
+	}
+	if status.Latency != 0 {
+		t.Errorf("Latency = %v, want 0", status.Latency)
+	}
+}
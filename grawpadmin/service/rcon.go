@@ -0,0 +1,79 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+	"github.com/WilkinsonK/grawp/grawpadmin/service/rcon"
+)
+
+const defaultRconHost = "127.0.0.1"
+const defaultRconPort = "25575"
+const defaultRconTimeout = 5 * time.Second
+
+// Get the address used to reach the RCON server of a
+// service from the host.
+//
+// The container port is read from the `RconPort` property
+// and translated into its published host port, if any.
+func RconAddressFromManifest(sm manifest.ServiceManifest) (string, error) {
+	host := sm.GetPropertyOr("RconHost", defaultRconHost)
+	port, err := sm.GetHostPort(sm.GetPropertyOr("RconPort", defaultRconPort))
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// Connect to the RCON server of a service using values
+// from its `ServiceManifest`.
+func RconDialFromManifest(sm manifest.ServiceManifest) (*rcon.Client, error) {
+	if enabled, err := strconv.ParseBool(sm.GetPropertyOr("EnableRcon", "false")); err != nil || !enabled {
+		return nil, fmt.Errorf("RCON is not enabled for service %s", sm.Name)
+	}
+
+	address, err := RconAddressFromManifest(sm)
+	if err != nil {
+		return nil, err
+	}
+	return rcon.Dial(address, sm.GetPropertyOr("RconPassword", ""), defaultRconTimeout)
+}
+
+// Send commands to the RCON server, one per line read from
+// `in`, until the input is exhausted.
+func RconRepl(client *rcon.Client, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	for fmt.Fprint(out, "rcon> "); scanner.Scan(); fmt.Fprint(out, "rcon> ") {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == "exit" || line == "quit" {
+			return nil
+		}
+		if err := RconSend(client, line, out); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(out)
+	return scanner.Err()
+}
+
+// Send a single command to the RCON server, writing the
+// response to `out`.
+func RconSend(client *rcon.Client, command string, out io.Writer) error {
+	resp, err := client.Command(command)
+	if err != nil {
+		return err
+	}
+	if resp != "" {
+		fmt.Fprintln(out, strings.TrimRight(resp, "\n"))
+	}
+	return nil
+}
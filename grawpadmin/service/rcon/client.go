@@ -0,0 +1,194 @@
+// Implements the client side of the Source RCON protocol
+// used by Minecraft servers for remote administration.
+package rcon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PacketTypeResponse int32 = 0
+	PacketTypeCommand  int32 = 2
+	PacketTypeAuth     int32 = 3
+)
+
+// Packets are limited to 4096 bytes of payload by the
+// Minecraft server. Anything larger is either split or
+// rejected.
+const MaxPayloadSize = 4096
+
+// Size of the id, type and trailing null bytes.
+const packetHeaderSize = 10
+
+var AuthFailedError = fmt.Errorf("rcon: authentication failed")
+
+type Packet struct {
+	Id      int32
+	Type    int32
+	Payload string
+}
+
+// Encode the packet into its wire format.
+func (p *Packet) MarshalBinary() ([]byte, error) {
+	if len(p.Payload) > MaxPayloadSize {
+		return nil, fmt.Errorf("rcon: payload exceeds %d bytes", MaxPayloadSize)
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, int32(len(p.Payload)+packetHeaderSize))
+	binary.Write(&buf, binary.LittleEndian, p.Id)
+	binary.Write(&buf, binary.LittleEndian, p.Type)
+	buf.WriteString(p.Payload)
+	buf.Write([]byte{0, 0})
+	return buf.Bytes(), nil
+}
+
+// Read a single packet from some reader.
+func ReadPacket(r io.Reader) (Packet, error) {
+	var p Packet
+	var size int32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return p, err
+	}
+	if size < packetHeaderSize || size > MaxPayloadSize+packetHeaderSize {
+		return p, fmt.Errorf("rcon: invalid packet size %d", size)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return p, err
+	}
+	p.Id = int32(binary.LittleEndian.Uint32(body[0:4]))
+	p.Type = int32(binary.LittleEndian.Uint32(body[4:8]))
+	p.Payload = string(bytes.TrimRight(body[8:], "\x00"))
+	return p, nil
+}
+
+// Write a single packet to some writer.
+func WritePacket(w io.Writer, p Packet) error {
+	data, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+type Client struct {
+	conn    net.Conn
+	lock    sync.Mutex
+	nextId  int32
+	Timeout time.Duration
+}
+
+// Authenticate against the server using `password`.
+func (C *Client) Auth(password string) error {
+	C.lock.Lock()
+	defer C.lock.Unlock()
+
+	id := C.id()
+	if err := C.write(Packet{Id: id, Type: PacketTypeAuth, Payload: password}); err != nil {
+		return err
+	}
+
+	// Some servers send an empty response value ahead of
+	// the actual auth response.
+	for {
+		p, err := C.read()
+		if err != nil {
+			return err
+		}
+		if p.Type != PacketTypeCommand {
+			continue
+		}
+		if p.Id == -1 || p.Id != id {
+			return AuthFailedError
+		}
+		return nil
+	}
+}
+
+func (C *Client) Close() error {
+	return C.conn.Close()
+}
+
+// Send a command to the server and return its response.
+//
+// Responses larger than a single packet are split by the
+// server. To find the end of a response, an empty packet
+// is sent after the command; the server answers it only
+// once the command response has been fully written.
+func (C *Client) Command(command string) (string, error) {
+	C.lock.Lock()
+	defer C.lock.Unlock()
+
+	id, end := C.id(), C.id()
+	if err := C.write(Packet{Id: id, Type: PacketTypeCommand, Payload: command}); err != nil {
+		return "", err
+	}
+	if err := C.write(Packet{Id: end, Type: PacketTypeResponse}); err != nil {
+		return "", err
+	}
+
+	var buf strings.Builder
+	for {
+		p, err := C.read()
+		if err != nil {
+			return buf.String(), err
+		}
+		if p.Id == end {
+			return buf.String(), nil
+		}
+		if p.Id == id {
+			buf.WriteString(p.Payload)
+		}
+	}
+}
+
+func (C *Client) id() int32 {
+	C.nextId++
+	return C.nextId
+}
+
+func (C *Client) read() (Packet, error) {
+	if C.Timeout > 0 {
+		C.conn.SetReadDeadline(time.Now().Add(C.Timeout))
+	}
+	return ReadPacket(C.conn)
+}
+
+func (C *Client) write(p Packet) error {
+	if C.Timeout > 0 {
+		C.conn.SetWriteDeadline(time.Now().Add(C.Timeout))
+	}
+	return WritePacket(C.conn, p)
+}
+
+// Create a new client from an existing connection.
+func ClientNew(conn net.Conn) *Client {
+	return &Client{conn: conn}
+}
+
+// Connect to an RCON server at `address` and authenticate
+// with `password`.
+func Dial(address, password string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := ClientNew(conn)
+	c.Timeout = timeout
+	if err = c.Auth(password); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,165 @@
+package rcon
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const fakePassword = "secret"
+
+// An in-process RCON server answering commands with
+// `respond`. Responses larger than a packet are split the
+// way a Minecraft server splits them.
+type fakeServer struct {
+	listener net.Listener
+	respond  func(command string) string
+}
+
+func (F *fakeServer) Addr() string {
+	return F.listener.Addr().String()
+}
+
+func (F *fakeServer) serve() {
+	for {
+		conn, err := F.listener.Accept()
+		if err != nil {
+			return
+		}
+		go F.handle(conn)
+	}
+}
+
+func (F *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		p, err := ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p.Type {
+		case PacketTypeAuth:
+			// An empty response value precedes the auth
+			// response, as some servers send.
+			WritePacket(conn, Packet{Id: p.Id, Type: PacketTypeResponse})
+			id := p.Id
+			if p.Payload != fakePassword {
+				id = -1
+			}
+			WritePacket(conn, Packet{Id: id, Type: PacketTypeCommand})
+		case PacketTypeCommand:
+			response := F.respond(p.Payload)
+			for {
+				chunk := response[:min(len(response), MaxPayloadSize)]
+				WritePacket(conn, Packet{Id: p.Id, Type: PacketTypeResponse, Payload: chunk})
+				response = response[len(chunk):]
+				if response == "" {
+					break
+				}
+			}
+		default:
+			// Echo anything else back, which the client
+			// relies on to find the end of a response.
+			WritePacket(conn, Packet{Id: p.Id, Type: PacketTypeResponse})
+		}
+	}
+}
+
+func fakeServerNew(t *testing.T, respond func(string) string) *fakeServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	F := &fakeServer{listener: listener, respond: respond}
+	go F.serve()
+	return F
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	want := Packet{Id: 7, Type: PacketTypeCommand, Payload: "list"}
+	if err := WritePacket(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != len(want.Payload)+packetHeaderSize+4 {
+		t.Errorf("encoded %d bytes, want %d", buf.Len(), len(want.Payload)+packetHeaderSize+4)
+	}
+	got, err := ReadPacket(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("ReadPacket() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPacketPayloadTooLarge(t *testing.T) {
+	p := Packet{Payload: strings.Repeat("a", MaxPayloadSize+1)}
+	if _, err := p.MarshalBinary(); err == nil {
+		t.Error("MarshalBinary() of an oversized payload succeeded")
+	}
+}
+
+func TestDialAuth(t *testing.T) {
+	server := fakeServerNew(t, func(string) string { return "" })
+	client, err := Dial(server.Addr(), fakePassword, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+}
+
+func TestDialAuthFailed(t *testing.T) {
+	server := fakeServerNew(t, func(string) string { return "" })
+	client, err := Dial(server.Addr(), "wrong", time.Second)
+	if !errors.Is(err, AuthFailedError) {
+		t.Fatalf("Dial() error = %v, want %v", err, AuthFailedError)
+	}
+	if client != nil {
+		t.Error("Dial() returned a client on failed auth")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	server := fakeServerNew(t, func(command string) string {
+		return "ran " + command
+	})
+	client, err := Dial(server.Addr(), fakePassword, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, command := range []string{"list", "save-all"} {
+		got, err := client.Command(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "ran " + command; got != want {
+			t.Errorf("Command(%q) = %q, want %q", command, got, want)
+		}
+	}
+}
+
+func TestCommandMultiPacket(t *testing.T) {
+	want := strings.Repeat("0123456789", MaxPayloadSize/4)
+	server := fakeServerNew(t, func(string) string { return want })
+	client, err := Dial(server.Addr(), fakePassword, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	got, err := client.Command("help")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("Command() returned %d bytes, want %d", len(got), len(want))
+	}
+}
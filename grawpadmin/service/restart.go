@@ -0,0 +1,139 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+	"github.com/docker/docker/api/types/container"
+)
+
+var CrashLoopError = fmt.Errorf("crash loop detected")
+
+// Describes how a service container exited.
+type RestartExit struct {
+	Error      string
+	ExitCode   int
+	FinishedAt time.Time
+	OOMKilled  bool
+	Unhealthy  bool
+}
+
+// Decides whether a service should be restarted after its
+// container exits, and how long to wait before doing so.
+type RestartPolicy interface {
+	Next(exit RestartExit) (bool, time.Duration, error)
+}
+
+type RestartPolicyCallback func(manifest.ServiceManifestRestart) RestartPolicy
+
+var restartPolicies = map[string]RestartPolicyCallback{
+	manifest.RestartPolicyAlways: func(settings manifest.ServiceManifestRestart) RestartPolicy {
+		return &AlwaysRestartPolicy{RestartBackoff{Settings: settings}}
+	},
+	manifest.RestartPolicyNever: func(settings manifest.ServiceManifestRestart) RestartPolicy {
+		return &NeverRestartPolicy{}
+	},
+	manifest.RestartPolicyOnFailure: func(settings manifest.ServiceManifestRestart) RestartPolicy {
+		return &OnFailureRestartPolicy{RestartBackoff{Settings: settings}}
+	},
+}
+
+// Tracks recent restarts to compute exponential backoff
+// delays and detect crash loops.
+type RestartBackoff struct {
+	Restarts []time.Time
+	Settings manifest.ServiceManifestRestart
+}
+
+// Record a restart, returning the delay to wait before
+// restarting.
+//
+// Fails if the maximum number of restarts within the
+// window has been reached.
+func (Rb *RestartBackoff) Next(now time.Time) (time.Duration, error) {
+	settings := Rb.Settings
+	Rb.Restarts = slices.DeleteFunc(Rb.Restarts, func(t time.Time) bool {
+		return now.Sub(t) > settings.Window
+	})
+
+	count := len(Rb.Restarts)
+	if uint(count) >= settings.MaxRestarts {
+		return 0, fmt.Errorf("%w: %d restarts within %s", CrashLoopError, count, settings.Window)
+	}
+	Rb.Restarts = append(Rb.Restarts, now)
+
+	backoff := settings.Backoff
+	delay := float64(backoff.Initial) * math.Pow(backoff.Multiplier, float64(count))
+	delay = min(delay, float64(backoff.Max))
+	delay *= 1 + backoff.Jitter*(2*rand.Float64()-1)
+	return time.Duration(delay), nil
+}
+
+// Restarts the service whenever it exits.
+type AlwaysRestartPolicy struct {
+	Backoff RestartBackoff
+}
+
+func (P *AlwaysRestartPolicy) Next(exit RestartExit) (bool, time.Duration, error) {
+	delay, err := P.Backoff.Next(time.Now())
+	return err == nil, delay, err
+}
+
+// Never restarts the service.
+type NeverRestartPolicy struct{}
+
+func (P *NeverRestartPolicy) Next(exit RestartExit) (bool, time.Duration, error) {
+	return false, 0, nil
+}
+
+// Restarts the service only if it exits with an exit code
+// that is not allowed, was killed for running out of
+// memory or is unhealthy.
+type OnFailureRestartPolicy struct {
+	Backoff RestartBackoff
+}
+
+func (P *OnFailureRestartPolicy) Next(exit RestartExit) (bool, time.Duration, error) {
+	if !exit.OOMKilled && !exit.Unhealthy && slices.Contains(P.Backoff.Settings.AllowedExitCodes, exit.ExitCode) {
+		return false, 0, nil
+	}
+	delay, err := P.Backoff.Next(time.Now())
+	return err == nil, delay, err
+}
+
+// Register a restart policy that can be selected by name
+// from a service manifest.
+func RestartPolicyRegister(name string, callback RestartPolicyCallback) {
+	restartPolicies[name] = callback
+}
+
+// Create the restart policy described by a
+// `ServiceManifest`.
+func RestartPolicyFromManifest(sm manifest.ServiceManifest) (RestartPolicy, error) {
+	settings := sm.GetRestart()
+	callback, ok := restartPolicies[settings.Policy]
+	if !ok {
+		return nil, fmt.Errorf("Unknown restart policy '%s'", settings.Policy)
+	}
+	return callback(settings), nil
+}
+
+// Describe how a container exited from its state.
+func RestartExitFromState(state *container.State) RestartExit {
+	exit := RestartExit{
+		Error:     state.Error,
+		ExitCode:  state.ExitCode,
+		OOMKilled: state.OOMKilled,
+	}
+	if finished, err := time.Parse(time.RFC3339Nano, state.FinishedAt); err == nil {
+		exit.FinishedAt = finished
+	}
+	if state.Running && state.Health != nil {
+		exit.Unhealthy = state.Health.Status == container.Unhealthy
+	}
+	return exit
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+)
+
+const restoreDateLayout = "20060102150405"
+
+// Options selecting which archive of a target is restored.
+type ArchiveRestoreOpts struct {
+	// Date, as YYYYMMDD, of the archive to restore.
+	Date string
+	// Restore the most recent archive.
+	Latest bool
+	// Stop the service container if it is running,
+	// starting it again once restored.
+	Stop bool
+	// Name of the archive target.
+	Target string
+}
+
+// Pick the archive to restore from `files`, ordered most
+// recent first. Of several archives made on the same date,
+// the most recent is picked.
+func ArchiveFileSelect(files []ArchiveFile, opts ArchiveRestoreOpts) (ArchiveFile, error) {
+	if len(files) == 0 {
+		return ArchiveFile{}, fmt.Errorf("No archives found for target '%s'", opts.Target)
+	}
+	if opts.Date == "" {
+		if !opts.Latest {
+			return ArchiveFile{}, fmt.Errorf("Either a date or latest must be given")
+		}
+		return files[0], nil
+	}
+	if opts.Latest {
+		return ArchiveFile{}, fmt.Errorf("A date and latest cannot both be given")
+	}
+
+	if _, err := time.ParseInLocation(archiveDateLayout, opts.Date, time.Local); err != nil {
+		return ArchiveFile{}, fmt.Errorf("Invalid archive date '%s'; expected YYYYMMDD", opts.Date)
+	}
+	for _, file := range files {
+		if file.Date.Format(archiveDateLayout) == opts.Date {
+			return file, nil
+		}
+	}
+	return ArchiveFile{}, fmt.Errorf("No archive of target '%s' dated %s", opts.Target, opts.Date)
+}
+
+// Find an archive target of a service by name.
+func ArchiveTargetFind(sm manifest.ServiceManifest, name string) (manifest.ServiceManifestArchiveTarget, error) {
+	targets := sm.GetArchiveTargets()
+	idx := slices.IndexFunc(targets, func(t manifest.ServiceManifestArchiveTarget) bool {
+		return t.Name == name
+	})
+	if idx < 0 {
+		return manifest.ServiceManifestArchiveTarget{}, fmt.Errorf("No archive target named '%s' for service %s", name, sm.Name)
+	}
+	return targets[idx], nil
+}
+
+// Get the name an archive of `target` is given to keep the
+// state it had before being restored over.
+//
+// These names are not matched by `ArchiveFileParse` and
+// so are never pruned.
+func ArchiveRestoreSafetyName(target string, date time.Time) string {
+	return fmt.Sprintf("%s.pre-restore-%s.tar.gz", target, date.Format(restoreDateLayout))
+}
+
+// Get the path, relative to `root`, an archive entry is
+// extracted to. Entries placed under `prefix` are taken
+// out of it.
+//
+// Older archives hold entries named after the path on the
+// host they were made on. These are made relative to
+// `root` where possible. Entries which would end up
+// outside of `root` are rejected.
+func ArchiveEntryPath(name, root, prefix string) (string, error) {
+	path := filepath.Clean(filepath.FromSlash(name))
+	if prefix = filepath.Clean(filepath.FromSlash(prefix)); prefix != "." {
+		if rel, err := filepath.Rel(prefix, path); err == nil && filepath.IsLocal(rel) {
+			path = rel
+		}
+	}
+	if filepath.IsAbs(path) {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return "", err
+		}
+		if rel, err := filepath.Rel(abs, path); err == nil {
+			path = rel
+		}
+	} else if rel, err := filepath.Rel(filepath.Clean(root), path); err == nil && filepath.IsLocal(rel) {
+		path = rel
+	}
+
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("Archive entry '%s' is outside of %s", name, root)
+	}
+	return path, nil
+}
+
+// Remove the files and symlinks covered by the archiver
+// from its root, returning the number removed. Whatever the
+// patterns of the target do not cover is left as is.
+func ArchiveTargetClear(a *Archiver) (uint, error) {
+	var count uint
+	root := a.Options.RootPath
+	if _, err := os.Lstat(root); errors.Is(err, fs.ErrNotExist) {
+		return count, nil
+	}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		if d.IsDir() {
+			if a.Options.Exclude.MatchDirectory(a.RelativePath(path)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !a.PathIsAllowed(path, false) {
+			return nil
+		}
+		if err = os.Remove(path); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// Extract an archive into `root`, returning the number of
+// entries written. Files not in the archive are left as
+// is. Modes and modification times are restored as well.
+func ArchiveExtract(path, root, prefix string, out io.Writer) (uint, error) {
+	var count uint
+	file, err := os.Open(path)
+	if err != nil {
+		return count, err
+	}
+	defer file.Close()
+
+	dr, err := ArchiveDecompressorNew(file)
+	if err != nil {
+		return count, err
+	}
+	defer dr.Close()
+
+	if err = os.MkdirAll(root, defaultFileMode); err != nil {
+		return count, err
+	}
+	// Writing through `os.Root` keeps symlinks already in
+	// the target from redirecting files outside of it.
+	dest, err := os.OpenRoot(root)
+	if err != nil {
+		return count, err
+	}
+	defer dest.Close()
+
+	// Directories are only given their mode and times once
+	// everything in them has been written.
+	var dirs []*tar.Header
+	tr := tar.NewReader(dr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return count, archiveExtractDirs(dest, dirs)
+		}
+		if err != nil {
+			return count, err
+		}
+
+		name, err := ArchiveEntryPath(header.Name, root, prefix)
+		if err != nil {
+			return count, err
+		}
+		if name == "." || header.Name == ArchiveContentsName {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = dest.MkdirAll(name, header.FileInfo().Mode().Perm()|0700)
+			header.Name = name
+			dirs = append(dirs, header)
+		case tar.TypeReg:
+			err = archiveExtractFile(dest, name, header, tr)
+		case tar.TypeSymlink:
+			err = archiveExtractSymlink(dest, name, header)
+		default:
+			fmt.Fprintf(out, "Skipped: %s (unsupported entry type)\n", header.Name)
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+		fmt.Fprintln(out, "Restored: ", filepath.Join(root, name))
+	}
+}
+
+func archiveExtractFile(dest *os.Root, name string, header *tar.Header, r io.Reader) error {
+	if err := dest.MkdirAll(filepath.Dir(name), defaultFileMode); err != nil {
+		return err
+	}
+	// Replace, rather than write through, whatever is at
+	// the path already.
+	if err := dest.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	file, err := dest.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, header.FileInfo().Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = dest.Chmod(name, header.FileInfo().Mode().Perm()); err != nil {
+		return err
+	}
+	return dest.Chtimes(name, header.ModTime, header.ModTime)
+}
+
+// Restore the mode and times of extracted directories,
+// deepest first.
+func archiveExtractDirs(dest *os.Root, dirs []*tar.Header) error {
+	for _, header := range slices.Backward(dirs) {
+		if err := dest.Chmod(header.Name, header.FileInfo().Mode().Perm()); err != nil {
+			return err
+		}
+		if err := dest.Chtimes(header.Name, header.ModTime, header.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func archiveExtractSymlink(dest *os.Root, name string, header *tar.Header) error {
+	link := filepath.FromSlash(header.Linkname)
+	if filepath.IsAbs(link) || !filepath.IsLocal(filepath.Join(filepath.Dir(name), link)) {
+		return fmt.Errorf("Archive entry '%s' links outside of the target", header.Name)
+	}
+	if err := dest.MkdirAll(filepath.Dir(name), defaultFileMode); err != nil {
+		return err
+	}
+	if err := dest.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return dest.Symlink(link, name)
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Excluded directories are left alone entirely, even where
+// a negated pattern names a file below them.
+func TestArchiveTargetClear(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]bool{
+		"logs/keep.txt":   true,
+		"logs/latest.log": true,
+		"world/level.dat": false,
+		"server.jar":      false,
+	}
+	for name := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, path, name)
+	}
+
+	a := ArchiverNew(ArchiveOptsNew("clear.tar", t.TempDir(), root, io.Discard))
+	if err := a.AddExcludes("logs/", "!logs/keep.txt"); err != nil {
+		t.Fatal(err)
+	}
+	count, err := ArchiveTargetClear(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("ArchiveTargetClear() removed %d files, want 2", count)
+	}
+	for name, kept := range files {
+		_, err := os.Stat(filepath.Join(root, name))
+		if kept && err != nil {
+			t.Errorf("%s removed: %v", name, err)
+		}
+		if !kept && !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s kept: %v", name, err)
+		}
+	}
+}
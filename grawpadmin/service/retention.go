@@ -0,0 +1,175 @@
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+	"github.com/WilkinsonK/grawp/grawpadmin/service/models"
+)
+
+const archiveDateLayout = "20060102"
+
+// Archives are named as <target>-<YYYYMMDD><extension>,
+// with delta archives of incremental targets named as
+// <target>-<YYYYMMDD>.delta<extension>. Checksum files
+// next to them are not matched.
+var archiveNamePattern = regexp.MustCompile(`^(.+)-(\d{8})(\.delta)?(\.tar(?:\.\w+)?)$`)
+
+// An archive file of some archive target.
+type ArchiveFile struct {
+	Date   time.Time
+	Delta  bool
+	Name   string
+	Path   string
+	Size   int64
+	Target string
+}
+
+// Get the file name an archive of `target` made on `date`
+// is written as, such as with the extension ".tar.gz".
+func ArchiveFileName(target string, date time.Time, ext string) string {
+	return fmt.Sprintf("%s-%s%s", target, date.Format(archiveDateLayout), ext)
+}
+
+// Get the file name a delta archive of `target` made on
+// `date` is written as.
+func ArchiveDeltaFileName(target string, date time.Time, ext string) string {
+	return fmt.Sprintf("%s-%s.delta%s", target, date.Format(archiveDateLayout), ext)
+}
+
+// Parse the target and date from an archive file name.
+func ArchiveFileParse(name string) (ArchiveFile, bool) {
+	matches := archiveNamePattern.FindStringSubmatch(name)
+	if matches == nil {
+		return ArchiveFile{}, false
+	}
+	date, err := time.ParseInLocation(archiveDateLayout, matches[2], time.Local)
+	if err != nil {
+		return ArchiveFile{}, false
+	}
+	return ArchiveFile{Date: date, Delta: matches[3] != "", Name: name, Target: matches[1]}, true
+}
+
+// Find the archives of `target` in archive storage, most
+// recent first.
+func ArchiveFilesFind(storage ArchiveStorage, target string) ([]ArchiveFile, error) {
+	var files []ArchiveFile
+	objects, err := storage.List()
+	if err != nil {
+		return files, err
+	}
+
+	for _, object := range objects {
+		file, ok := ArchiveFileParse(object.Name)
+		if !ok || file.Target != target {
+			continue
+		}
+		file.Path = object.Path
+		file.Size = object.Size
+		files = append(files, file)
+	}
+
+	ArchiveFilesSort(files)
+	return files, nil
+}
+
+// Sort archives most recent first.
+func ArchiveFilesSort(files []ArchiveFile) {
+	slices.SortFunc(files, func(a, b ArchiveFile) int {
+		return cmp.Or(b.Date.Compare(a.Date), cmp.Compare(b.Name, a.Name))
+	})
+}
+
+// Decide which archives are kept and which are removed
+// under a retention policy. `files` must be ordered most
+// recent first.
+//
+// Once the archives kept would exceed the size limit, that
+// archive and every older one are removed. The most recent
+// archive is always kept, even if larger than the limit.
+func RetentionPlan(files []ArchiveFile, retention manifest.ServiceManifestRetention) ([]ArchiveFile, []ArchiveFile, error) {
+	if !retention.IsDefined() {
+		return files, nil, nil
+	}
+	maxSize, err := retention.GetMaxSize()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kept := make([]bool, len(files))
+	for i := range files {
+		kept[i] = uint(i) < retention.KeepLast
+	}
+	retentionKeepBuckets(files, kept, retention.KeepDaily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	retentionKeepBuckets(files, kept, retention.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	retentionKeepBuckets(files, kept, retention.KeepMonthly, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+
+	// With no keep rules, only the size limit applies.
+	if retention.KeepDaily+retention.KeepLast+retention.KeepMonthly+retention.KeepWeekly == 0 {
+		for i := range kept {
+			kept[i] = true
+		}
+	}
+
+	var total int64
+	var full bool
+	var keep, remove []ArchiveFile
+	for i, file := range files {
+		if kept[i] && i > 0 && maxSize > 0 && total+file.Size > maxSize {
+			full = true
+		}
+		if kept[i] && !full {
+			total += file.Size
+			keep = append(keep, file)
+		} else {
+			remove = append(remove, file)
+		}
+	}
+	return keep, remove, nil
+}
+
+// Move the archives kept deltas are reconstructed from out
+// of `remove`, returning what is left to remove.
+func RetentionKeepChains(keep, remove []ArchiveFile, snapshots []models.ArchiveSnapshot) []ArchiveFile {
+	needed := make(map[string]bool)
+	for _, file := range keep {
+		chain, err := ArchiveSnapshotChain(snapshots, file.Name)
+		if err != nil {
+			continue
+		}
+		for _, snapshot := range chain {
+			needed[snapshot.Archive] = true
+		}
+	}
+	return slices.DeleteFunc(remove, func(file ArchiveFile) bool {
+		return needed[file.Name]
+	})
+}
+
+// Keep the most recent archive of each of the `count` most
+// recent buckets.
+func retentionKeepBuckets(files []ArchiveFile, kept []bool, count uint, bucket func(time.Time) string) {
+	var seen []string
+	for i, file := range files {
+		if uint(len(seen)) >= count {
+			return
+		}
+		key := bucket(file.Date)
+		if slices.Contains(seen, key) {
+			continue
+		}
+		seen = append(seen, key)
+		kept[i] = true
+	}
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+)
+
+// The day `n` days before the most recent archive.
+func retentionDay(n int) time.Time {
+	return time.Date(2026, 10, 16, 0, 0, 0, 0, time.Local).AddDate(0, 0, -n)
+}
+
+// Archives of the given sizes, one a day, most recent
+// first.
+func retentionFiles(sizes ...int64) []ArchiveFile {
+	var files []ArchiveFile
+	for i, size := range sizes {
+		files = append(files, ArchiveFile{
+			Date: retentionDay(i),
+			Name: fmt.Sprintf("a%d", i),
+			Size: size,
+		})
+	}
+	return files
+}
+
+func archiveFileNames(files []ArchiveFile) []string {
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	return names
+}
+
+func TestRetentionPlan(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      []ArchiveFile
+		retention  manifest.ServiceManifestRetention
+		wantKeep   []string
+		wantRemove []string
+	}{
+		{
+			name:     "no retention",
+			files:    retentionFiles(1, 1),
+			wantKeep: []string{"a0", "a1"},
+		},
+		{
+			name:       "keep last",
+			files:      retentionFiles(1, 1, 1, 1),
+			retention:  manifest.ServiceManifestRetention{KeepLast: 2},
+			wantKeep:   []string{"a0", "a1"},
+			wantRemove: []string{"a2", "a3"},
+		},
+		{
+			name:       "max size fits the most recent",
+			files:      retentionFiles(2, 2, 2, 2),
+			retention:  manifest.ServiceManifestRetention{MaxSize: "6"},
+			wantKeep:   []string{"a0", "a1", "a2"},
+			wantRemove: []string{"a3"},
+		},
+		{
+			name:       "max size removes every archive older than the limit",
+			files:      retentionFiles(3, 4, 1, 1),
+			retention:  manifest.ServiceManifestRetention{MaxSize: "6"},
+			wantKeep:   []string{"a0"},
+			wantRemove: []string{"a1", "a2", "a3"},
+		},
+		{
+			name:       "max size keeps the most recent archive over the limit",
+			files:      retentionFiles(10, 1, 1),
+			retention:  manifest.ServiceManifestRetention{MaxSize: "5"},
+			wantKeep:   []string{"a0"},
+			wantRemove: []string{"a1", "a2"},
+		},
+		{
+			name:       "max size with keep rules",
+			files:      retentionFiles(1, 1, 1, 1),
+			retention:  manifest.ServiceManifestRetention{KeepLast: 3, MaxSize: "2"},
+			wantKeep:   []string{"a0", "a1"},
+			wantRemove: []string{"a2", "a3"},
+		},
+		{
+			name: "max size ignores archives removed by keep rules",
+			files: []ArchiveFile{
+				{Date: retentionDay(0), Name: "a0", Size: 1},
+				{Date: retentionDay(0), Name: "a0-earlier", Size: 100},
+				{Date: retentionDay(1), Name: "a1", Size: 1},
+			},
+			retention:  manifest.ServiceManifestRetention{KeepDaily: 2, MaxSize: "5"},
+			wantKeep:   []string{"a0", "a1"},
+			wantRemove: []string{"a0-earlier"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep, remove, err := RetentionPlan(tt.files, tt.retention)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := archiveFileNames(keep); !slices.Equal(got, tt.wantKeep) {
+				t.Errorf("kept %q, want %q", got, tt.wantKeep)
+			}
+			if got := archiveFileNames(remove); !slices.Equal(got, tt.wantRemove) {
+				t.Errorf("removed %q, want %q", got, tt.wantRemove)
+			}
+		})
+	}
+}
+
+func TestRetentionPlanInvalidMaxSize(t *testing.T) {
+	_, _, err := RetentionPlan(retentionFiles(1), manifest.ServiceManifestRetention{MaxSize: "lots"})
+	if err == nil {
+		t.Error("RetentionPlan() with an invalid max size succeeded")
+	}
+}
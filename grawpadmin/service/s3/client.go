@@ -0,0 +1,297 @@
+// Implements a minimal client of the S3 object storage API,
+// signing requests with AWS Signature Version 4. Works
+// against AWS as well as S3-compatible servers such as
+// MinIO.
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	amzDateLayout   = "20060102T150405Z"
+	emptyPayloadSum = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	signAlgorithm   = "AWS4-HMAC-SHA256"
+)
+
+var NotFoundError = fmt.Errorf("s3: object not found")
+
+// An object as listed in a bucket.
+type Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+}
+
+type listBucketResult struct {
+	Contents              []Object `xml:"Contents"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+}
+
+type errorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type Client struct {
+	AccessKey string
+	Bucket    string
+	// Base URL of the service, such as
+	// "https://s3.us-east-1.amazonaws.com".
+	Endpoint string
+	HTTP     *http.Client
+	// Address the bucket as part of the path rather than
+	// the host name.
+	PathStyle bool
+	Region    string
+	SecretKey string
+}
+
+// Remove an object. Removing an object which does not
+// exist is not an error.
+func (C *Client) Delete(key string) error {
+	req, err := C.request(http.MethodDelete, key, nil, nil, emptyPayloadSum)
+	if err != nil {
+		return err
+	}
+	resp, err := C.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Read an object. The body must be closed by the caller.
+func (C *Client) Get(key string) (io.ReadCloser, error) {
+	req, err := C.request(http.MethodGet, key, nil, nil, emptyPayloadSum)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := C.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// List every object under a key prefix.
+func (C *Client) List(prefix string) ([]Object, error) {
+	var objects []Object
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := C.request(http.MethodGet, "", query, nil, emptyPayloadSum)
+		if err != nil {
+			return objects, err
+		}
+		resp, err := C.do(req)
+		if err != nil {
+			return objects, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return objects, fmt.Errorf("s3: invalid list response: %w", err)
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Write an object of `size` bytes read from `body`.
+// `sum` is the hex encoded SHA-256 of the body.
+//
+// Objects are written in a single request, so are limited
+// to the 5GB the API allows.
+func (C *Client) Put(key string, body io.Reader, size int64, sum string) error {
+	req, err := C.request(http.MethodPut, key, nil, body, sum)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := C.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (C *Client) do(req *http.Request) (*http.Response, error) {
+	client := C.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && req.Method != http.MethodDelete {
+		return nil, fmt.Errorf("%w: %s", NotFoundError, req.URL.Path)
+	}
+	var apiErr errorResponse
+	xml.NewDecoder(resp.Body).Decode(&apiErr)
+	if apiErr.Code == "" {
+		return nil, fmt.Errorf("s3: %s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return nil, fmt.Errorf("s3: %s %s: %s: %s", req.Method, req.URL.Path, apiErr.Code, apiErr.Message)
+}
+
+// Create a signed request for an object, or for the
+// bucket itself if `key` is empty.
+func (C *Client) request(method, key string, query url.Values, body io.Reader, sum string) (*http.Request, error) {
+	base, err := url.Parse(C.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	path := "/" + key
+	if C.PathStyle {
+		path = "/" + C.Bucket + path
+	} else {
+		base.Host = C.Bucket + "." + base.Host
+	}
+	base.Path = path
+	base.RawPath = encodePath(path)
+	base.RawQuery = encodeQuery(query)
+
+	req, err := http.NewRequest(method, base.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", sum)
+	Sign(req, C.AccessKey, C.SecretKey, C.Region, time.Now())
+	return req, nil
+}
+
+// Create a client for a bucket. Without an endpoint, the
+// AWS endpoint of the region is used.
+func ClientNew(endpoint, region, bucket, accessKey, secretKey string) *Client {
+	if region == "" {
+		region = "us-east-1"
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	return &Client{
+		AccessKey: accessKey,
+		Bucket:    bucket,
+		Endpoint:  strings.TrimRight(endpoint, "/"),
+		Region:    region,
+		SecretKey: secretKey,
+	}
+}
+
+// Sign a request with AWS Signature Version 4, signing the
+// host and every header already set on the request.
+//
+// The X-Amz-Content-Sha256 header must already be set.
+func Sign(req *http.Request, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(amzDateLayout)
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var canonical strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonical, "%s:%s\n", name, headers[name])
+	}
+	signed := strings.Join(names, ";")
+
+	request := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		encodeQuery(req.URL.Query()),
+		canonical.String(),
+		signed,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, region)
+	toSign := strings.Join([]string{signAlgorithm, amzDate, scope, hashHex(request)}, "\n")
+
+	key := hmacSum([]byte("AWS4"+secretKey), date)
+	key = hmacSum(key, region)
+	key = hmacSum(key, "s3")
+	key = hmacSum(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSum(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signAlgorithm, accessKey, scope, signed, signature))
+}
+
+// Percent encode everything but unreserved characters, as
+// required by the canonical request.
+func encode(s string, keepSlash bool) string {
+	var buf strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', keepSlash && b == '/':
+			buf.WriteByte(b)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+	return buf.String()
+}
+
+func encodePath(path string) string {
+	return encode(path, true)
+}
+
+// Encode a query sorted by key, as required by the
+// canonical request.
+func encodeQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, encode(key, false)+"="+encode(value, false))
+		}
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func hashHex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSum(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+	"github.com/WilkinsonK/grawp/grawpadmin/output"
+	"github.com/WilkinsonK/grawp/grawpadmin/service/models"
+	"github.com/WilkinsonK/grawp/grawpadmin/service/ping"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-units"
+)
+
+// A snapshot of a running service combining its container
+// state, resource usage and the status reported by the
+// server itself.
+type ServiceStatus struct {
+	Name          string    `json:"name"`
+	DockerId      string    `json:"docker_id"`
+	State         string    `json:"state"`
+	StartedAt     time.Time `json:"started_at,omitzero"`
+	Uptime        string    `json:"uptime,omitempty"`
+	CpuPercent    float64   `json:"cpu_percent"`
+	MemoryUsage   uint64    `json:"memory_usage"`
+	MemoryLimit   uint64    `json:"memory_limit"`
+	PlayersOnline int       `json:"players_online"`
+	PlayersMax    int       `json:"players_max"`
+	Motd          string    `json:"motd,omitempty"`
+	Version       string    `json:"version,omitempty"`
+	Protocol      int       `json:"protocol,omitempty"`
+	Latency       string    `json:"latency,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Compute the CPU usage, as a percentage of a single core
+// times the number of cores, between two stat samples.
+func StatsCpuPercent(stats container.StatsResponse) float64 {
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	return cpuDelta / systemDelta * cpus * 100
+}
+
+// Compute the memory in use, excluding the page cache.
+func StatsMemoryUsage(stats container.StatsResponse) uint64 {
+	usage := stats.MemoryStats.Usage
+	// cgroup v1 and v2 report the page cache differently.
+	for _, key := range []string{"total_inactive_file", "inactive_file"} {
+		if cache, ok := stats.MemoryStats.Stats[key]; ok && cache < usage {
+			return usage - cache
+		}
+	}
+	return usage
+}
+
+// Gather the status of a single service container.
+//
+// Stats and the server status are only gathered while the
+// container is running.
+func ServiceStatusFromContainer(cli *client.Client, model models.ServiceContainer, sm manifest.ServiceManifest) (ServiceStatus, error) {
+	ctx := context.Background()
+	status := ServiceStatus{Name: model.Name, DockerId: model.DockerId}
+
+	resp, err := cli.ContainerInspect(ctx, model.DockerId)
+	if err != nil {
+		return status, err
+	}
+	status.State = resp.State.Status
+	if !resp.State.Running {
+		return status, nil
+	}
+	if started, err := time.Parse(time.RFC3339Nano, resp.State.StartedAt); err == nil {
+		status.StartedAt = started
+		status.Uptime = time.Since(started).Round(time.Second).String()
+	}
+
+	stats, err := cli.ContainerStats(ctx, model.DockerId, false)
+	if err != nil {
+		return status, err
+	}
+	defer stats.Body.Close()
+
+	var sr container.StatsResponse
+	if err = json.NewDecoder(stats.Body).Decode(&sr); err != nil {
+		return status, err
+	}
+	status.CpuPercent = StatsCpuPercent(sr)
+	status.MemoryUsage = StatsMemoryUsage(sr)
+	status.MemoryLimit = sr.MemoryStats.Limit
+
+	ServiceStatusPing(&status, sm)
+	return status, nil
+}
+
+// Fill in what the server reports to a Server List Ping on
+// its health check address. A server which cannot be
+// reached has the error recorded instead.
+func ServiceStatusPing(status *ServiceStatus, sm manifest.ServiceManifest) {
+	address, err := sm.GetHealthAddress()
+	if err != nil {
+		status.Error = err.Error()
+		return
+	}
+	ps, err := ping.Ping(address, sm.GetHealth().Timeout)
+	if err != nil {
+		status.Error = err.Error()
+		return
+	}
+	status.PlayersOnline = ps.Players.Online
+	status.PlayersMax = ps.Players.Max
+	status.Motd = ps.Description.String()
+	status.Version = ps.Version.Name
+	status.Protocol = ps.Version.Protocol
+	if ps.Latency > 0 {
+		status.Latency = ps.Latency.Round(time.Millisecond).String()
+	}
+}
+
+// Write service statuses in the given format.
+func WriteServiceStatus(out io.Writer, statuses []ServiceStatus, format output.Format) error {
+	return output.Write(out, format, statuses, []output.Column[ServiceStatus]{
+		{Header: "NAME", Value: func(s ServiceStatus) string { return s.Name }},
+		{Header: "STATE", Value: func(s ServiceStatus) string { return s.State }},
+		{Header: "UPTIME", Value: func(s ServiceStatus) string { return s.Uptime }},
+		{Header: "CPU", Value: func(s ServiceStatus) string { return fmt.Sprintf("%.2f%%", s.CpuPercent) }},
+		{Header: "MEMORY", Value: func(s ServiceStatus) string {
+			if s.MemoryUsage == 0 {
+				return ""
+			}
+			return fmt.Sprintf("%s / %s", units.BytesSize(float64(s.MemoryUsage)), units.BytesSize(float64(s.MemoryLimit)))
+		}},
+		{Header: "PLAYERS", Value: func(s ServiceStatus) string {
+			if s.PlayersMax == 0 {
+				return ""
+			}
+			return fmt.Sprintf("%d/%d", s.PlayersOnline, s.PlayersMax)
+		}},
+		{Header: "VERSION", Value: func(s ServiceStatus) string { return s.Version }},
+		{Header: "MOTD", Value: func(s ServiceStatus) string { return s.Motd }},
+	})
+}
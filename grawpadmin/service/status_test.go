@@ -0,0 +1,134 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+	"github.com/WilkinsonK/grawp/grawpadmin/service/ping"
+)
+
+const statusTestJson = `{"description":"A Minecraft Server","players":{"max":20,"online":3},"version":{"name":"1.21.10","protocol":773}}`
+
+// Answer status requests on a local port, returning the
+// port.
+func statusServer(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				// The handshake, then the status request.
+				for range 2 {
+					if _, _, err := ping.ReadPacket(r); err != nil {
+						return
+					}
+				}
+				var response bytes.Buffer
+				ping.WriteString(&response, statusTestJson)
+				ping.WritePacket(conn, ping.PacketIdStatus, response.Bytes())
+				if id, payload, err := ping.ReadPacket(r); err == nil {
+					ping.WritePacket(conn, id, payload)
+				}
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// A local port nothing listens on.
+func closedPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestServiceStatusPing(t *testing.T) {
+	gamePort, rconPort := statusServer(t), closedPort(t)
+	tests := []struct {
+		name     string
+		manifest string
+		wantErr  string
+	}{
+		{
+			name: "game port listed first",
+			manifest: fmt.Sprintf(`
+game-port: "25565"
+ports: ["%d:25565", "%d:25575"]`, gamePort, rconPort),
+		},
+		{
+			name: "game port not listed first",
+			manifest: fmt.Sprintf(`
+game-port: "25565"
+ports: ["%d:25575", "%d:25565"]`, rconPort, gamePort),
+		},
+		{
+			name: "health port",
+			manifest: fmt.Sprintf(`
+health: {port: "25565"}
+ports: ["%d:25575", "%d:25565"]`, rconPort, gamePort),
+		},
+		{
+			name: "no game port",
+			manifest: fmt.Sprintf(`
+ports: ["%d:25565", "%d:25575"]`, gamePort, rconPort),
+			wantErr: "neither a health check port nor a game port",
+		},
+		{
+			name: "server unreachable",
+			manifest: fmt.Sprintf(`
+game-port: "25565"
+ports: ["%d:25565"]`, rconPort),
+			wantErr: "refused",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm, err := manifest.LoadsManifest("service.yaml", []byte("name: papermc"+tt.manifest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var status ServiceStatus
+			ServiceStatusPing(&status, sm)
+
+			if tt.wantErr != "" {
+				if !strings.Contains(status.Error, tt.wantErr) {
+					t.Errorf("Error = %q, want it to contain %q", status.Error, tt.wantErr)
+				}
+				if status.PlayersMax != 0 {
+					t.Errorf("PlayersMax = %d of an unreachable server, want 0", status.PlayersMax)
+				}
+				return
+			}
+			if status.Error != "" {
+				t.Fatalf("Error = %q, want none", status.Error)
+			}
+			if status.PlayersOnline != 3 || status.PlayersMax != 20 {
+				t.Errorf("players = %d/%d, want 3/20", status.PlayersOnline, status.PlayersMax)
+			}
+			if status.Motd != "A Minecraft Server" || status.Version != "1.21.10" || status.Protocol != 773 {
+				t.Errorf("server = %q %q %d, want %q %q %d",
+					status.Motd, status.Version, status.Protocol, "A Minecraft Server", "1.21.10", 773)
+			}
+		})
+	}
+}
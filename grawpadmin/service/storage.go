@@ -0,0 +1,292 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
+	"github.com/WilkinsonK/grawp/grawpadmin/service/s3"
+)
+
+// Returned when a file is not in archive storage.
+var ArchiveNotStoredError = fmt.Errorf("Not in archive storage")
+
+// A file kept in archive storage.
+type ArchiveStorageObject struct {
+	Name string
+	// Where the file is kept, for display.
+	Path string
+	Size int64
+}
+
+// Where the archives of a service are kept once written.
+type ArchiveStorage interface {
+	// Copy a file from storage to a local path.
+	Download(name, path string) error
+	// List the files in storage.
+	List() ([]ArchiveStorageObject, error)
+	// Get where a file is kept, for display.
+	Location(name string) string
+	Remove(name string) error
+	// Copy a local file into storage as `name`.
+	Upload(path, name string) error
+}
+
+// Keeps archives in a directory.
+type LocalArchiveStorage struct {
+	Path string
+}
+
+func (Ls *LocalArchiveStorage) Download(name, path string) error {
+	return copyFile(filepath.Join(Ls.Path, name), path)
+}
+
+func (Ls *LocalArchiveStorage) List() ([]ArchiveStorageObject, error) {
+	var objects []ArchiveStorageObject
+	entries, err := os.ReadDir(Ls.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return objects, nil
+	}
+	if err != nil {
+		return objects, err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return objects, err
+		}
+		objects = append(objects, ArchiveStorageObject{
+			Name: entry.Name(),
+			Path: Ls.Location(entry.Name()),
+			Size: info.Size(),
+		})
+	}
+	return objects, nil
+}
+
+func (Ls *LocalArchiveStorage) Location(name string) string {
+	return filepath.Join(Ls.Path, name)
+}
+
+func (Ls *LocalArchiveStorage) Remove(name string) error {
+	err := os.Remove(Ls.Location(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ArchiveNotStoredError, Ls.Location(name))
+	}
+	return err
+}
+
+func (Ls *LocalArchiveStorage) Upload(path, name string) error {
+	if err := os.MkdirAll(Ls.Path, defaultFileMode); err != nil {
+		return err
+	}
+	return copyFile(path, Ls.Location(name))
+}
+
+// Keeps archives in a bucket of an S3-compatible object
+// storage, under a key prefix.
+type S3ArchiveStorage struct {
+	Client *s3.Client
+	Prefix string
+}
+
+func (Ss *S3ArchiveStorage) Download(name, path string) error {
+	body, err := Ss.Client.Get(Ss.Prefix + name)
+	if errors.Is(err, s3.NotFoundError) {
+		return fmt.Errorf("%w: %s", ArchiveNotStoredError, Ss.Location(name))
+	}
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return writeFile(path, body)
+}
+
+func (Ss *S3ArchiveStorage) List() ([]ArchiveStorageObject, error) {
+	var objects []ArchiveStorageObject
+	found, err := Ss.Client.List(Ss.Prefix)
+	if err != nil {
+		return objects, err
+	}
+	for _, object := range found {
+		name := strings.TrimPrefix(object.Key, Ss.Prefix)
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		objects = append(objects, ArchiveStorageObject{
+			Name: name,
+			Path: Ss.Location(name),
+			Size: object.Size,
+		})
+	}
+	return objects, nil
+}
+
+func (Ss *S3ArchiveStorage) Location(name string) string {
+	return fmt.Sprintf("s3://%s/%s%s", Ss.Client.Bucket, Ss.Prefix, name)
+}
+
+func (Ss *S3ArchiveStorage) Remove(name string) error {
+	return Ss.Client.Delete(Ss.Prefix + name)
+}
+
+func (Ss *S3ArchiveStorage) Upload(path, name string) error {
+	sum, err := fileSha256(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return Ss.Client.Put(Ss.Prefix+name, file, info.Size(), sum)
+}
+
+// Get the local path of an archive along with its
+// checksum file, downloading both if not kept locally.
+// The returned function removes whatever was downloaded.
+func ArchiveFetch(storage ArchiveStorage, archivePath, name string) (string, func(), error) {
+	if ArchiveStorageIsLocal(storage, archivePath) {
+		return filepath.Join(archivePath, name), func() {}, nil
+	}
+
+	if err := os.MkdirAll(archivePath, defaultFileMode); err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp(archivePath, ".fetch-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	path := filepath.Join(dir, name)
+	if err = storage.Download(name, path); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	err = storage.Download(ArchiveChecksumName(name), ArchiveChecksumName(path))
+	if err != nil && !errors.Is(err, ArchiveNotStoredError) {
+		cleanup()
+		return "", nil, err
+	}
+	return path, cleanup, nil
+}
+
+// Remove an archive along with its checksum file from
+// storage.
+func ArchiveStorageRemove(storage ArchiveStorage, name string) error {
+	if err := storage.Remove(name); err != nil {
+		return err
+	}
+	err := storage.Remove(ArchiveChecksumName(name))
+	if err != nil && !errors.Is(err, ArchiveNotStoredError) {
+		return err
+	}
+	return nil
+}
+
+// Whether archives are kept in `archivePath` itself,
+// rather than copied elsewhere once written.
+func ArchiveStorageIsLocal(storage ArchiveStorage, archivePath string) bool {
+	local, ok := storage.(*LocalArchiveStorage)
+	return ok && filepath.Clean(local.Path) == filepath.Clean(archivePath)
+}
+
+// Create the archive storage of a service. Without any
+// storage defined, archives are kept in `archivePath`.
+func ArchiveStorageNew(config manifest.GrawpManifestArchiveStorage, service, archivePath string) (ArchiveStorage, error) {
+	switch config.Kind {
+	case "":
+		return &LocalArchiveStorage{Path: archivePath}, nil
+	case manifest.ArchiveStorageLocal:
+		if config.Path == "" {
+			return nil, fmt.Errorf("Local archive storage requires a path")
+		}
+		return &LocalArchiveStorage{Path: filepath.Join(config.Path, service)}, nil
+	case manifest.ArchiveStorageS3:
+		if config.Bucket == "" {
+			return nil, fmt.Errorf("S3 archive storage requires a bucket")
+		}
+		accessKey, secretKey := config.AccessKey, config.SecretKey
+		if accessKey == "" {
+			accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		if secretKey == "" {
+			secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		client := s3.ClientNew(config.Endpoint, config.Region, config.Bucket, accessKey, secretKey)
+		client.PathStyle = config.PathStyle
+		return &S3ArchiveStorage{Client: client, Prefix: path.Join(config.Prefix, service) + "/"}, nil
+	default:
+		return nil, fmt.Errorf("Unknown archive storage '%s'; expected local or s3", config.Kind)
+	}
+}
+
+// Copy an archive and its checksum file into storage,
+// removing the local copies once stored elsewhere.
+func ArchiveUpload(storage ArchiveStorage, archivePath, name string, out io.Writer) error {
+	if ArchiveStorageIsLocal(storage, archivePath) {
+		return nil
+	}
+
+	path := filepath.Join(archivePath, name)
+	for _, file := range []string{path, ArchiveChecksumName(path)} {
+		fmt.Fprintf(out, "Uploading %s to %s...\n", file, storage.Location(filepath.Base(file)))
+		if err := storage.Upload(file, filepath.Base(file)); err != nil {
+			return err
+		}
+	}
+	return errors.Join(os.Remove(path), os.Remove(ArchiveChecksumName(path)))
+}
+
+func copyFile(src, dst string) error {
+	file, err := os.Open(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ArchiveNotStoredError, src)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return writeFile(dst, file)
+}
+
+// Write a file through a temporary file next to it, which
+// replaces it only once complete.
+func writeFile(path string, r io.Reader) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	file.Chmod(defaultFileMode)
+	if _, err = io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err = errors.Join(file.Sync(), file.Close()); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err = os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/WilkinsonK/grawp/grawpadmin/service/s3"
+	"github.com/WilkinsonK/grawp/grawpadmin/service/s3/s3test"
+)
+
+// Storage kept in a bucket of an in-memory S3 server.
+func s3StorageNew(t *testing.T) (*S3ArchiveStorage, *s3test.Server) {
+	t.Helper()
+	server := s3test.NewServer("grawp")
+	t.Cleanup(server.Close)
+	client := s3.ClientNew(server.URL, "", "grawp", s3test.AccessKey, s3test.SecretKey)
+	client.HTTP = server.HTTPClient()
+	return &S3ArchiveStorage{Client: client, Prefix: "archives/papermc/"}, server
+}
+
+func writeTestFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestArchiveUploadFetch(t *testing.T) {
+	storage, server := s3StorageNew(t)
+	archivePath := t.TempDir()
+	name := "world-2026-10-16.tar.gz"
+	path := filepath.Join(archivePath, name)
+	writeTestFile(t, path, "archive")
+	writeTestFile(t, ArchiveChecksumName(path), "checksum")
+
+	if err := ArchiveUpload(storage, archivePath, name, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"archives/papermc/" + name, "archives/papermc/" + name + ".sha256"}
+	if keys := server.Keys(); !slices.Equal(keys, want) {
+		t.Errorf("stored %q, want %q", keys, want)
+	}
+	for _, file := range []string{path, ArchiveChecksumName(path)} {
+		if _, err := os.Stat(file); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s kept after upload: %v", file, err)
+		}
+	}
+
+	fetched, cleanup, err := ArchiveFetch(storage, archivePath, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, fetched); got != "archive" {
+		t.Errorf("fetched archive = %q, want %q", got, "archive")
+	}
+	if got := readTestFile(t, ArchiveChecksumName(fetched)); got != "checksum" {
+		t.Errorf("fetched checksum = %q, want %q", got, "checksum")
+	}
+	cleanup()
+	if _, err = os.Stat(filepath.Dir(fetched)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("cleanup kept %s: %v", filepath.Dir(fetched), err)
+	}
+}
+
+// Archives written before checksums were kept have none.
+func TestArchiveFetchNoChecksum(t *testing.T) {
+	storage, _ := s3StorageNew(t)
+	archivePath := t.TempDir()
+	name := "world-2026-10-16.tar.gz"
+	path := filepath.Join(t.TempDir(), name)
+	writeTestFile(t, path, "archive")
+	if err := storage.Upload(path, name); err != nil {
+		t.Fatal(err)
+	}
+
+	fetched, cleanup, err := ArchiveFetch(storage, archivePath, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if got := readTestFile(t, fetched); got != "archive" {
+		t.Errorf("fetched archive = %q, want %q", got, "archive")
+	}
+	if _, err = os.Stat(ArchiveChecksumName(fetched)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("fetched a checksum which is not stored: %v", err)
+	}
+	if sum, err := ArchiveChecksumRead(fetched); err != nil || sum != "" {
+		t.Errorf("ArchiveChecksumRead() = %q, %v; want no checksum", sum, err)
+	}
+}
+
+func TestArchiveFetchNotStored(t *testing.T) {
+	storage, _ := s3StorageNew(t)
+	archivePath := t.TempDir()
+	_, _, err := ArchiveFetch(storage, archivePath, "missing.tar.gz")
+	if !errors.Is(err, ArchiveNotStoredError) {
+		t.Errorf("ArchiveFetch() error = %v, want %v", err, ArchiveNotStoredError)
+	}
+	entries, _ := os.ReadDir(archivePath)
+	if len(entries) != 0 {
+		t.Errorf("ArchiveFetch() left %d entries in %s", len(entries), archivePath)
+	}
+}
+
+func TestS3ArchiveStorage(t *testing.T) {
+	storage, _ := s3StorageNew(t)
+	dir := t.TempDir()
+	for _, name := range []string{"a.tar.gz", "b.tar.gz", "b.tar.gz.sha256"} {
+		path := filepath.Join(dir, name)
+		writeTestFile(t, path, name)
+		if err := storage.Upload(path, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Objects under deeper prefixes are not archives of the
+	// service.
+	writeTestFile(t, filepath.Join(dir, "nested"), "nested")
+	if err := storage.Upload(filepath.Join(dir, "nested"), "old/c.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := storage.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, object := range objects {
+		names = append(names, object.Name)
+		if object.Size != int64(len(object.Name)) {
+			t.Errorf("size of %s = %d, want %d", object.Name, object.Size, len(object.Name))
+		}
+	}
+	if want := []string{"a.tar.gz", "b.tar.gz", "b.tar.gz.sha256"}; !slices.Equal(names, want) {
+		t.Errorf("List() = %q, want %q", names, want)
+	}
+	if got, want := storage.Location("a.tar.gz"), "s3://grawp/archives/papermc/a.tar.gz"; got != want {
+		t.Errorf("Location() = %s, want %s", got, want)
+	}
+
+	if err = ArchiveStorageRemove(storage, "b.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ArchiveStorageRemove(storage, "a.tar.gz"); err != nil {
+		t.Errorf("ArchiveStorageRemove() without a checksum error = %v", err)
+	}
+	if objects, _ = storage.List(); len(objects) != 0 {
+		t.Errorf("List() after removal = %v, want none", objects)
+	}
+	err = storage.Download("a.tar.gz", filepath.Join(dir, "fetched"))
+	if !errors.Is(err, ArchiveNotStoredError) {
+		t.Errorf("Download() of a removed archive error = %v, want %v", err, ArchiveNotStoredError)
+	}
+}
@@ -1,17 +1,25 @@
 package service
 
 import (
+	"cmp"
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"slices"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/WilkinsonK/grawp/grawpadmin/manifest"
 	"github.com/WilkinsonK/grawp/grawpadmin/service/models"
+	"github.com/WilkinsonK/grawp/grawpadmin/service/ping"
+	"github.com/WilkinsonK/grawp/grawpadmin/util"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 )
 
@@ -19,24 +27,92 @@ var DoneError = fmt.Errorf("done")
 
 type WatchArgs struct {
 	Client     *client.Client
+	Done       chan bool
 	Error      error
+	Logger     *log.Logger
+	Manifest   manifest.ServiceManifest
 	Model      models.ServiceContainer
+	Policy     RestartPolicy
+	Probe      chan error
 	Response   *container.InspectResponse
 	RetryCount uint
 	RetryDelay time.Duration
 	RetryMax   uint
+	Unhealthy  bool
 	WatchDelay time.Duration
 }
 
 type WatchCallback func(*WatchArgs) error
 
+// A service container to be watched along with the
+// manifest it was created from.
+type WatchTarget struct {
+	Manifest manifest.ServiceManifest
+	Model    models.ServiceContainer
+}
+
 type Watcher struct {
 	args   WatchArgs
 	broker *ServiceBroker
 }
 
-func (w *Watcher) Watch(name string) error {
-	return WatchImageService(w.broker.Client, w.broker.Database, name)
+// Watch service containers by name.
+//
+// Services are started after the services they depend on
+// and by their declared start order, falling back to the
+// order they are given in.
+func (w *Watcher) Watch(names ...string) error {
+	var targets []WatchTarget
+	for _, name := range names {
+		model, sm, err := w.broker.FindServiceContainer(name)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, WatchTarget{Manifest: sm, Model: model})
+	}
+
+	rank, err := w.startOrder()
+	if err != nil {
+		return err
+	}
+	slices.SortStableFunc(targets, func(a, b WatchTarget) int {
+		return cmp.Or(
+			cmp.Compare(rank[a.Manifest.Name], rank[b.Manifest.Name]),
+			cmp.Compare(a.Manifest.StartOrder, b.Manifest.StartOrder))
+	})
+	return WatchImageServices(w.broker.Client, targets...)
+}
+
+// Rank services under the services path by their
+// dependency order.
+func (w *Watcher) startOrder() (map[string]int, error) {
+	rank := make(map[string]int)
+	manifests, err := w.broker.Manifest.LoadServiceManifests()
+	if err != nil {
+		return rank, err
+	}
+	order, err := manifest.ResolveServiceOrder(manifests)
+	if err != nil {
+		return rank, err
+	}
+	for i, sm := range order {
+		rank[sm.Name] = i
+	}
+	return rank, nil
+}
+
+// Watch all known service containers.
+func (w *Watcher) WatchAll() error {
+	found, err := models.ServiceContainerFind(w.broker.Database, models.ServiceContainerFindOpts{})
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("No service containers to watch")
+	}
+	return w.Watch(util.Collect(slices.Values(found), func(m models.ServiceContainer) string {
+		return m.Name
+	})...)
 }
 
 func SetDoneError(args *WatchArgs) {
@@ -47,132 +123,536 @@ func IsDoneError(args *WatchArgs) bool {
 	return args.Error != nil && args.Error == DoneError
 }
 
-func IsStoppedOk(args *WatchArgs) bool {
+func IsUnhealthy(args *WatchArgs) bool {
 	state := args.Response.State
-	return state.Status == "exited" && state.Error == ""
+	if !state.Running {
+		return false
+	}
+	return args.Unhealthy || (state.Health != nil && state.Health.Status == container.Unhealthy)
 }
 
-func IsStoppedErr(args *WatchArgs) bool {
+func IsStopped(args *WatchArgs) bool {
 	state := args.Response.State
-	return state.Status == "exited" && state.Error != ""
-}
-
-func ShouldRestart(args *WatchArgs) bool {
-	return IsStoppedErr(args)
+	return state.Status == "exited" || state.Status == "dead"
 }
 
+// Whether watching was interrupted, either by closing
+// `args.Done` or by `SetDoneError`.
 func ShouldStop(args *WatchArgs) bool {
-	return IsDoneError(args)
+	select {
+	case <-args.Done:
+		return true
+	default:
+		return IsDoneError(args)
+	}
 }
 
+// Restart a stopped service if its restart policy allows
+// it. Returns `DoneError` if the service should stay
+// stopped.
 func WatchRestart(args *WatchArgs) error {
-	log.Println("Restarting service...")
-	return args.Client.ContainerRestart(context.Background(), args.Model.DockerId, container.StopOptions{})
+	exit := RestartExitFromState(args.Response.State)
+	exit.Unhealthy = exit.Unhealthy || IsUnhealthy(args)
+	if exit.Unhealthy {
+		args.Logger.Println("Service is unhealthy")
+	} else {
+		args.Logger.Printf("Service exited with code %d\n", exit.ExitCode)
+	}
+
+	restart, delay, err := args.Policy.Next(exit)
+	if err != nil {
+		return err
+	}
+	if !restart {
+		args.Logger.Println("Service will not be restarted")
+		return DoneError
+	}
+
+	args.Logger.Printf("Restarting service in %s...\n", delay.Round(time.Millisecond))
+	if !WatchWaitFor(args, delay) {
+		return nil
+	}
+	err = args.Client.ContainerRestart(context.Background(), args.Model.DockerId, container.StopOptions{})
+
+	// Probe failures reported while the service was down
+	// no longer apply.
+	args.Unhealthy = false
+	for len(args.Probe) > 0 {
+		<-args.Probe
+	}
+	return err
 }
 
-func WatchRetryFailure(args *WatchArgs) {
-	if args.Error != nil && args.RetryCount != 0 && !IsDoneError(args) {
-		log.Printf("Error: %s\n", args.Error)
-		WatchRetryWait(args)
-		args.Error = nil
-		args.RetryCount--
+// Probe the service with a Server List Ping at a regular
+// interval, reporting on `args.Probe` once the failure
+// threshold is met. Runs until `stop` or `args.Done` is
+// closed.
+func WatchProbe(args *WatchArgs, stop chan bool) {
+	health := args.Manifest.GetHealth()
+	address, err := args.Manifest.GetHealthAddress()
+	if err != nil {
+		args.Logger.Printf("Error: health check disabled: %s\n", err)
 		return
 	}
-	if args.Error != nil && !IsDoneError(args) {
-		log.Printf("Error: maximum retries met\n")
-		return
+
+	var failures uint
+	wait := health.StartPeriod
+	for {
+		select {
+		case <-time.After(wait):
+		case <-stop:
+			return
+		case <-args.Done:
+			return
+		}
+
+		wait = health.Interval
+		if _, err = ping.Ping(address, health.Timeout); err == nil {
+			failures = 0
+			continue
+		}
+		failures++
+		args.Logger.Printf("Health check failed (%d/%d): %s\n", failures, health.FailureThreshold, err)
+		if failures < health.FailureThreshold {
+			continue
+		}
+
+		// Give the service time to start up again once it
+		// has been restarted.
+		failures = 0
+		wait = health.StartPeriod
+		select {
+		case args.Probe <- err:
+		case <-stop:
+			return
+		case <-args.Done:
+			return
+		}
 	}
-	args.RetryCount = args.RetryMax
 }
 
-func WatchRetryWait(args *WatchArgs) {
-	time.Sleep(args.RetryDelay)
+// Mark the service as unhealthy after a failed probe.
+func WatchProbeFailed(args *WatchArgs, err error) {
+	args.Logger.Printf("Service stopped answering status requests: %s\n", err)
+	args.Unhealthy = true
+}
+
+// Wait before retrying a failed inspection. Returns false
+// if watching was interrupted.
+func WatchRetryWait(args *WatchArgs) bool {
+	return WatchWaitFor(args, args.RetryDelay)
 }
 
 func WatchStart(args *WatchArgs) error {
-	log.Println("Service starting...")
+	args.Logger.Println("Service starting...")
 	return args.Client.ContainerStart(context.Background(), args.Model.DockerId, container.StartOptions{})
 }
 
+// Send the shutdown commands to the server console by
+// attaching to the container's stdin.
+//
+// Containers created before stdin was kept open cannot be
+// sent anything, and must be recreated first.
+func WatchShutdownAttach(args *WatchArgs, commands []string) error {
+	ctx := context.Background()
+	inspect, err := args.Client.ContainerInspect(ctx, args.Model.DockerId)
+	if err != nil {
+		return err
+	}
+	if inspect.Config == nil || !inspect.Config.OpenStdin {
+		return fmt.Errorf("Service container %s was created without an open stdin; recreate it with 'services build --recreate'", args.Model.Name)
+	}
+
+	resp, err := args.Client.ContainerAttach(ctx, args.Model.DockerId, container.AttachOptions{
+		Stream: true,
+		Stdin:  true,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	for _, command := range commands {
+		if _, err = fmt.Fprintln(resp.Conn, command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send the shutdown commands to the server console over
+// RCON.
+func WatchShutdownRcon(args *WatchArgs, commands []string) error {
+	client, err := RconDialFromManifest(args.Manifest)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for _, command := range commands {
+		if _, err = client.Command(command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ask the server to save and exit on its own.
+func WatchShutdownCommands(args *WatchArgs, shutdown manifest.ServiceManifestShutdown) error {
+	if shutdown.Method == manifest.ShutdownMethodRcon {
+		args.Logger.Println("Sending shutdown commands over RCON...")
+		err := WatchShutdownRcon(args, shutdown.Commands)
+		if err == nil {
+			return nil
+		}
+		args.Logger.Printf("Error: RCON shutdown failed: %s\n", err)
+	}
+	args.Logger.Println("Sending shutdown commands through attached stdin...")
+	return WatchShutdownAttach(args, shutdown.Commands)
+}
+
+// Wait for the service container to stop running. Returns
+// false if it is still running after `timeout`.
+func WatchShutdownWait(args *WatchArgs, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	statusCh, errCh := args.Client.ContainerWait(ctx, args.Model.DockerId, container.WaitConditionNotRunning)
+	select {
+	case <-statusCh:
+		return true
+	case err := <-errCh:
+		if err != nil && ctx.Err() == nil {
+			args.Logger.Printf("Error: %s\n", err)
+		}
+		return false
+	}
+}
+
+// Stop the service container, giving the server a chance
+// to save its world before falling back to stopping, and
+// then killing, the container.
 func WatchStop(args *WatchArgs) error {
-	log.Println("Service stopping...")
-	return args.Client.ContainerStop(context.Background(), args.Model.DockerId, container.StopOptions{})
+	args.Logger.Println("Service stopping...")
+	ctx := context.Background()
+	shutdown := args.Manifest.GetShutdown()
+
+	if err := WatchShutdownCommands(args, shutdown); err != nil {
+		args.Logger.Printf("Error: in-game shutdown failed: %s\n", err)
+	} else {
+		args.Logger.Printf("Waiting up to %s for service to exit...\n", shutdown.GracePeriod)
+		if WatchShutdownWait(args, shutdown.GracePeriod) {
+			args.Logger.Println("Service exited")
+			return nil
+		}
+		args.Logger.Println("Grace period expired")
+	}
+
+	args.Logger.Println("Stopping service container...")
+	err := args.Client.ContainerStop(ctx, args.Model.DockerId, container.StopOptions{})
+	if err == nil {
+		return nil
+	}
+	args.Logger.Printf("Error: %s\n", err)
+
+	args.Logger.Println("Killing service container...")
+	return args.Client.ContainerKill(ctx, args.Model.DockerId, "SIGKILL")
 }
 
-func WatchWait(args *WatchArgs, err *error) {
-	time.Sleep(args.WatchDelay)
+// Wait for the given duration. Returns false if watching
+// was interrupted.
+func WatchWaitFor(args *WatchArgs, delay time.Duration) bool {
+	select {
+	case <-time.After(delay):
+		return true
+	case <-args.Done:
+		return false
+	}
 }
 
+// Subscribe to the Docker events of the service container,
+// passing the container state to `callback` whenever it
+// changes.
+//
+// Returns false along with the stream error if the event
+// stream drops before watching is done.
+func WatchEvents(args *WatchArgs, callback WatchCallback) (bool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	msgs, errs := args.Client.Events(ctx, events.ListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("container", args.Model.DockerId),
+			filters.Arg("event", string(events.ActionStart)),
+			filters.Arg("event", string(events.ActionDie)),
+			filters.Arg("event", string(events.ActionOOM)),
+			filters.Arg("event", string(events.ActionHealthStatus)),
+		),
+	})
+
+	// Catch up on anything that happened before the
+	// subscription was made.
+	if done, err := WatchStep(args, callback); done {
+		return true, err
+	}
+
+	for {
+		select {
+		case msg := <-msgs:
+			args.Logger.Printf("Service event: %s\n", msg.Action)
+			if done, err := WatchStep(args, callback); done {
+				return true, err
+			}
+		case err := <-errs:
+			return false, err
+		case err := <-args.Probe:
+			WatchProbeFailed(args, err)
+			if done, err := WatchStep(args, callback); done {
+				return true, err
+			}
+		case <-args.Done:
+			if done, err := WatchStep(args, callback); done {
+				return true, err
+			}
+		}
+	}
+}
+
+// Poll the service container state for the given duration,
+// passing it to `callback` on each inspection.
+func WatchPoll(args *WatchArgs, callback WatchCallback, duration time.Duration) (bool, error) {
+	deadline := time.Now().Add(duration)
+	for time.Now().Before(deadline) {
+		if done, err := WatchStep(args, callback); done {
+			return true, err
+		}
+		WatchWaitFor(args, args.WatchDelay)
+	}
+	return false, nil
+}
+
+// Inspect the service container and pass its state to
+// `callback`. Returns true once watching should end.
+//
+// Failed inspections are retried up to `RetryMax` times in
+// a row.
+func WatchStep(args *WatchArgs, callback WatchCallback) (bool, error) {
+	select {
+	case err := <-args.Probe:
+		WatchProbeFailed(args, err)
+	default:
+	}
+
+	resp, err := args.Client.ContainerInspect(context.Background(), args.Model.DockerId)
+	if err != nil {
+		if args.RetryCount == 0 {
+			args.Logger.Printf("Error: maximum retries met\n")
+			return true, err
+		}
+		args.Logger.Printf("Error: %s\n", err)
+		args.RetryCount--
+		WatchRetryWait(args)
+		return false, nil
+	}
+	args.RetryCount = args.RetryMax
+	args.Response = &resp
+
+	err = callback(args)
+	return ShouldStop(args) || err != nil, err
+}
+
+// Watch the service container until `callback` fails or
+// watching is interrupted.
+//
+// Changes are picked up from the Docker event stream.
+// Should the stream drop, the container is polled for a
+// while before subscribing again.
 func Watch(args *WatchArgs, callback WatchCallback) error {
-	ctx := context.Background()
 	for {
-		resp, err := args.Client.ContainerInspect(ctx, args.Model.DockerId)
-		if WatchRetryFailure(args); err != nil {
+		done, err := WatchEvents(args, callback)
+		if done {
 			return err
 		}
-		args.Response = &resp
-
-		err = callback(args)
-		if ShouldStop(args) || err != nil {
+		args.Logger.Printf("Error: event stream dropped: %s\n", err)
+		args.Logger.Printf("Polling service for %s...\n", args.RetryDelay)
+		if done, err = WatchPoll(args, callback, args.RetryDelay); done {
 			return err
 		}
-		WatchWait(args, &err)
 	}
 }
 
-func WatchImageService(cli *client.Client, db *sql.DB, name string) error {
-	models, err := models.ServiceContainerFind(db, models.ServiceContainerFindOpts{
-		Name: name,
-	})
+// Create the arguments used to watch a single service
+// container. Watching is interrupted once `done` is closed.
+//
+// Services with health checks enabled must define the
+// port they are probed on.
+func WatchArgsNew(cli *client.Client, done chan bool, target WatchTarget) (*WatchArgs, error) {
+	policy, err := RestartPolicyFromManifest(target.Manifest)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	args := WatchArgs{
-		Client:     cli,
-		Error:      nil,
-		Model:      models[0],
-		RetryCount: 3,
-		RetryMax:   3,
-		RetryDelay: 10 * time.Second,
-		WatchDelay: 500 * time.Millisecond,
+	if target.Manifest.GetHealth().Enabled {
+		if _, err = target.Manifest.GetHealthAddress(); err != nil {
+			return nil, err
+		}
 	}
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
-	done := make(chan bool, 1)
+	args := WatchArgsShutdownNew(cli, target)
+	args.Done = done
+	args.Policy = policy
+	args.Probe = make(chan error, 1)
+	args.RetryCount = 3
+	args.RetryMax = 3
+	args.RetryDelay = 10 * time.Second
+	args.WatchDelay = 500 * time.Millisecond
+	return args, nil
+}
 
-	go func() {
-		_ = <-sigChan
-		SetDoneError(&args)
-		done <- true
-	}()
+// Create only what `WatchShutdown` needs to stop a service
+// container. The restart policy is left out, so a service
+// with an invalid policy can still be stopped.
+func WatchArgsShutdownNew(cli *client.Client, target WatchTarget) *WatchArgs {
+	prefix := fmt.Sprintf("[%s] ", target.Model.Name)
+	return &WatchArgs{
+		Client:   cli,
+		Logger:   log.New(os.Stderr, prefix, log.LstdFlags|log.Lmsgprefix),
+		Manifest: target.Manifest,
+		Model:    target.Model,
+	}
+}
+
+// Stop the service container if it is still running.
+func WatchShutdown(args *WatchArgs) error {
+	resp, err := args.Client.ContainerInspect(context.Background(), args.Model.DockerId)
+	if err != nil {
+		return err
+	}
+	if !resp.State.Running {
+		return nil
+	}
+	return WatchStop(args)
+}
 
-	log.Println("Waiting for service...")
-	Watch(&args, func(wa *WatchArgs) error {
+// Start the service container and wait for it to be
+// running.
+func WatchStartup(args *WatchArgs) error {
+	args.Logger.Println("Waiting for service...")
+	err := Watch(args, func(wa *WatchArgs) error {
 		if wa.Response.State.Running {
 			return DoneError
 		} else {
 			return WatchStart(wa)
 		}
 	})
-	log.Println("Service running")
+	if err != nil && err != DoneError {
+		return err
+	}
+	if !ShouldStop(args) {
+		args.Logger.Println("Service running")
+	}
+	return nil
+}
 
-	Watch(&args, func(wa *WatchArgs) error {
-		if IsStoppedErr(wa) || IsStoppedOk(wa) {
-			if err := WatchRestart(wa); err != nil {
-				return err
-			}
-		}
+// Restart the service container according to its restart
+// policy until watching is interrupted or the policy gives
+// up on the service.
+//
+// If enabled, the service is also probed with a Server
+// List Ping and restarted once it stops answering.
+func WatchSupervise(args *WatchArgs) error {
+	if args.Manifest.GetHealth().Enabled {
+		stop := make(chan bool)
+		defer close(stop)
+		go WatchProbe(args, stop)
+	}
+
+	err := Watch(args, func(wa *WatchArgs) error {
 		if ShouldStop(wa) {
-			WatchStop(wa)
+			return nil
+		}
+		if IsStopped(wa) || IsUnhealthy(wa) {
+			return WatchRestart(wa)
 		}
 		return nil
 	})
 
+	if err != nil && err != DoneError {
+		args.Logger.Printf("Error: %s\n", err)
+		return err
+	}
 	return nil
 }
 
+// Watch one or more service containers from a single
+// process.
+//
+// Services are started one after the other in the order
+// given, each being supervised in its own goroutine once
+// running. On SIGINT or SIGTERM, services are stopped in
+// reverse order.
+func WatchImageServices(cli *client.Client, targets ...WatchTarget) error {
+	done := make(chan bool)
+	var watched []*WatchArgs
+	for _, target := range targets {
+		args, err := WatchArgsNew(cli, done, target)
+		if err != nil {
+			return err
+		}
+		watched = append(watched, args)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigChan)
+
+	// Closing `done` is the only way the watchers are told
+	// to stop, as the arguments of each are not shared.
+	go func() {
+		_ = <-sigChan
+		close(done)
+	}()
+
+	var errs []error
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var started []*WatchArgs
+	for _, args := range watched {
+		if ShouldStop(args) {
+			break
+		}
+		if err := WatchStartup(args); err != nil {
+			args.Logger.Printf("Error: %s\n", err)
+			lock.Lock()
+			errs = append(errs, err)
+			lock.Unlock()
+			continue
+		}
+		started = append(started, args)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := WatchSupervise(args); err != nil {
+				lock.Lock()
+				errs = append(errs, err)
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, args := range slices.Backward(started) {
+		if err := WatchShutdown(args); err != nil {
+			args.Logger.Printf("Error: %s\n", err)
+			lock.Lock()
+			errs = append(errs, err)
+			lock.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func WatcherNew(broker *ServiceBroker) *Watcher {
 	return &Watcher{broker: broker}
 }